@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// auditEntry is one JSON line in the audit log: enough to reconstruct what
+// was synced when, without duplicating the full case payload.
+type auditEntry struct {
+	Time        string `json:"ts"`
+	SyncBatchID string `json:"syncBatchId,omitempty"`
+	CdlID       string `json:"cdlId"`
+	Type        string `json:"type,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"`
+}
+
+// auditSink appends a JSON line per upserted case to AUDIT_LOG_PATH,
+// independent of application logs, for compliance trails. Writes go through
+// a buffered channel and a single writer goroutine so the sync path never
+// blocks on disk; if the buffer fills, entries are dropped and counted
+// rather than stalling upserts.
+type auditSink struct {
+	path     string
+	maxBytes int64
+	ch       chan auditEntry
+	done     chan struct{}
+}
+
+var auditLog *auditSink
+
+func initAudit(cfg *Config) error {
+	if cfg.AuditLogPath == "" {
+		auditLog = nil
+		return nil
+	}
+	// Open eagerly so a bad path fails at startup, not mid-message.
+	f, err := os.OpenFile(cfg.AuditLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s := &auditSink{
+		path:     cfg.AuditLogPath,
+		maxBytes: int64(cfg.AuditLogMaxBytes),
+		ch:       make(chan auditEntry, 1024),
+		done:     make(chan struct{}),
+	}
+	go s.run(f)
+	auditLog = s
+	return nil
+}
+
+func (s *auditSink) run(f *os.File) {
+	defer close(s.done)
+	defer f.Close()
+	for entry := range s.ch {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		if s.maxBytes > 0 {
+			if info, err := f.Stat(); err == nil && info.Size()+int64(len(line)) > s.maxBytes {
+				f = s.rotate(f)
+			}
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			log.WithError(err).Error("Failed to write audit log entry")
+		}
+	}
+}
+
+// rotate moves the full file aside to <path>.1 (replacing any previous
+// rotation) and starts a fresh one, keeping at most one generation around.
+func (s *auditSink) rotate(f *os.File) *os.File {
+	f.Close()
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		log.WithError(err).Error("Failed to rotate audit log")
+	}
+	next, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		// Fall back to the old handle rather than losing entries entirely.
+		log.WithError(err).Error("Failed to reopen audit log after rotation")
+		next, _ = os.OpenFile(s.path+".1", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	}
+	return next
+}
+
+// auditCases records one entry per case, non-blocking. Nil-safe so callers
+// need no feature check.
+func auditCases(ctx context.Context, cases []FraudCases) {
+	if auditLog == nil {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	batchID := syncBatchIDFrom(ctx)
+	for _, fc := range cases {
+		entry := auditEntry{
+			Time:        now,
+			SyncBatchID: batchID,
+			CdlID:       fc.CdlId,
+			Type:        fc.Type,
+			CountryCode: fc.BankCountryCode,
+		}
+		if entry.CountryCode == "" {
+			entry.CountryCode = fc.BusinessPartnerCountryCode
+		}
+		select {
+		case auditLog.ch <- entry:
+		default:
+			auditDropped.Inc()
+		}
+	}
+}
+
+// closeAudit flushes buffered entries and closes the file; call at shutdown.
+func closeAudit() {
+	if auditLog == nil {
+		return
+	}
+	close(auditLog.ch)
+	<-auditLog.done
+}
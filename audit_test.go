@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditSinkWritesOneLinePerCase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := initAudit(&Config{AuditLogPath: path}); err != nil {
+		t.Fatalf("initAudit: %v", err)
+	}
+	defer func() { auditLog = nil }()
+
+	ctx := withSyncBatchID(context.Background(), "batch-1")
+	auditCases(ctx, []FraudCases{
+		{CdlId: "case-1", Type: "PHISHING", BankCountryCode: "DE"},
+		{CdlId: "case-2", Type: "FAKE_PRESIDENT", BusinessPartnerCountryCode: "FR"},
+	})
+	closeAudit()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening audit log: %v", err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("audit line is not JSON: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", len(entries))
+	}
+	if entries[0].CdlID != "case-1" || entries[0].SyncBatchID != "batch-1" || entries[0].CountryCode != "DE" {
+		t.Errorf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].CountryCode != "FR" {
+		t.Errorf("expected business partner country as fallback, got %+v", entries[1])
+	}
+	if entries[0].Time == "" {
+		t.Error("expected a timestamp on audit entries")
+	}
+}
+
+func TestAuditSinkRotatesAtSizeLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := initAudit(&Config{AuditLogPath: path, AuditLogMaxBytes: 100}); err != nil {
+		t.Fatalf("initAudit: %v", err)
+	}
+	defer func() { auditLog = nil }()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		auditCases(ctx, []FraudCases{{CdlId: "case", Type: "PHISHING", BankCountryCode: "DE"}})
+	}
+	closeAudit()
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated audit log at %s.1: %v", path, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 100 {
+		t.Fatalf("expected live audit log under the size limit, got %v, %v", info, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit breaker states, exported as the circuit_state gauge value.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a minimal per-endpoint circuit breaker. After threshold
+// consecutive transient failures it opens for the cooldown period, letting a
+// single probe through afterwards (half-open) before closing again.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	b := &breaker{name: name, threshold: threshold, cooldown: cooldown}
+	circuitState.WithLabelValues(name).Set(breakerClosed)
+	return b
+}
+
+// allow reports whether a request may proceed; when the circuit is open it
+// returns a transient error so the message is requeued rather than dropped.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		if time.Now().Before(b.openUntil) {
+			return transient("network", "circuit breaker for %s is open", b.name)
+		}
+		b.setState(breakerHalfOpen)
+	}
+	return nil
+}
+
+// record feeds a request outcome back into the breaker. Only transient
+// failures count towards opening the circuit; permanent errors (4xx, bad
+// payloads) say nothing about endpoint health.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !isTransient(err) {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	circuitState.WithLabelValues(b.name).Set(float64(state))
+	log.WithFields(log.Fields{"endpoint": b.name, "state": state}).Warn("Circuit breaker state changed")
+}
+
+var (
+	cdqBreaker     *breaker
+	catenaxBreaker *breaker
+)
+
+func initBreakers(cfg *Config) {
+	cdqBreaker = newBreaker("cdq", cfg.CircuitFailureThreshold, cfg.CircuitCooldown)
+	catenaxBreaker = newBreaker("catenax", cfg.CircuitFailureThreshold, cfg.CircuitCooldown)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// callBudget caps the total number of outbound API calls one message may
+// make, independent of page count: a hard ceiling against a single runaway
+// message burning the whole CDQ/Catenax quota. Every fetch attempt, upsert
+// and delete counts as one call.
+type callBudget struct {
+	mu    sync.Mutex
+	max   int
+	spent int
+}
+
+// newCallBudget returns nil unless MAX_API_CALLS_PER_MESSAGE is set.
+func newCallBudget(cfg *Config) *callBudget {
+	if cfg.MaxAPICallsPerMessage <= 0 {
+		return nil
+	}
+	return &callBudget{max: cfg.MaxAPICallsPerMessage}
+}
+
+// spend consumes one call from the budget. The error is permanent: a message
+// that blew its budget once would blow it again on redelivery.
+func (b *callBudget) spend() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent++
+	if b.spent > b.max {
+		apiBudgetExceeded.Inc()
+		return fmt.Errorf("API call budget of %d per message exceeded", b.max)
+	}
+	return nil
+}
+
+type callBudgetKey struct{}
+
+func withCallBudget(ctx context.Context, b *callBudget) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, callBudgetKey{}, b)
+}
+
+// spendAPICall charges one outbound call against the message's budget, if one
+// is attached to the context.
+func spendAPICall(ctx context.Context) error {
+	b, _ := ctx.Value(callBudgetKey{}).(*callBudget)
+	return b.spend()
+}
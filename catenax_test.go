@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeleteFraudCasesStatusHandling(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantErr   bool
+		transient bool
+	}{
+		{name: "204 no content", status: http.StatusNoContent},
+		{name: "200 with empty body", status: http.StatusOK},
+		{name: "409 conflict", status: http.StatusConflict, wantErr: true},
+		{name: "503 unavailable", status: http.StatusServiceUnavailable, wantErr: true, transient: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			cfg = loadConfig()
+			cfg.CatenaxAPIURL = server.URL
+			cfg.CatenaxMaxRetries = 0
+			cfg.CatenaxRetryBase = time.Millisecond
+			initBreakers(cfg)
+
+			err := deleteFraudCases(context.Background(), time.Now())
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("deleteFraudCases error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.transient != isTransient(err) {
+				t.Errorf("isTransient = %v, want %v", isTransient(err), tt.transient)
+			}
+		})
+	}
+}
+
+func TestUpsertRetriesOn503WithStableIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	keys := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		keys[r.Header.Get("Idempotency-Key")] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = server.URL
+	cfg.CatenaxMaxRetries = 2
+	cfg.CatenaxRetryBase = time.Millisecond
+	initBreakers(cfg)
+
+	if _, err := upsertFraudCases(context.Background(), []FraudCases{{CdlId: "a"}}); err == nil {
+		t.Fatal("expected the upsert to fail after exhausting retries")
+	}
+	if attempts != 3 {
+		t.Errorf("server saw %d attempts, want 3 (1 try + 2 retries)", attempts)
+	}
+	if len(keys) != 1 {
+		t.Errorf("server saw %d distinct idempotency keys, want 1", len(keys))
+	}
+}
+
+func TestUpsertAcceptsPlainTextSuccessBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = server.URL
+	initBreakers(cfg)
+
+	updatedAt, err := upsertFraudCases(context.Background(), []FraudCases{{CdlId: "a"}})
+	if err != nil {
+		t.Fatalf("upsert with plain-text 200 body: %v", err)
+	}
+	if !updatedAt.IsZero() {
+		t.Errorf("updatedAt = %v, want zero (no server timestamp to feed the cutoff)", updatedAt)
+	}
+}
+
+func TestDeleteTimeoutIsIndependentAndTransient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = server.URL
+	cfg.CatenaxMaxRetries = 0
+	cfg.CatenaxDeleteTimeout = 20 * time.Millisecond
+	initBreakers(cfg)
+
+	err := deleteFraudCases(context.Background(), time.Now())
+	if err == nil {
+		t.Fatal("expected the slow delete to hit its dedicated deadline")
+	}
+	if !isTransient(err) {
+		t.Errorf("delete timeout must be transient so the message requeues, got %v", err)
+	}
+	if got := transientReason(err); got != "timeout" {
+		t.Errorf("reason = %q, want \"timeout\"", got)
+	}
+
+	// A separate retry budget for deletes: 0 means one attempt even when the
+	// upsert path retries.
+	cfg.CatenaxDeleteMaxRetries = 2
+	if got := deleteMaxRetries(); got != 2 {
+		t.Errorf("deleteMaxRetries = %d, want 2", got)
+	}
+	cfg.CatenaxDeleteMaxRetries = -1
+	cfg.CatenaxMaxRetries = 5
+	if got := deleteMaxRetries(); got != 5 {
+		t.Errorf("deleteMaxRetries fallback = %d, want 5 (inherit upsert budget)", got)
+	}
+}
+
+func TestCatenaxStatusErrorClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		transient bool
+		contains  string
+	}{
+		{
+			name:      "known retryable code overrides 409",
+			status:    http.StatusConflict,
+			body:      `{"code":"WRITE_CONFLICT","message":"concurrent write"}`,
+			transient: true,
+			contains:  "WRITE_CONFLICT",
+		},
+		{
+			name:      "known permanent code overrides 503",
+			status:    http.StatusServiceUnavailable,
+			body:      `{"code":"VALIDATION_FAILED","message":"bad field"}`,
+			transient: false,
+			contains:  "VALIDATION_FAILED",
+		},
+		{
+			name:      "unknown code falls back to status",
+			status:    http.StatusServiceUnavailable,
+			body:      `{"code":"SOMETHING_NEW","message":"eh"}`,
+			transient: true,
+			contains:  "SOMETHING_NEW",
+		},
+		{
+			name:      "unstructured body falls back to status",
+			status:    http.StatusBadRequest,
+			body:      "not json",
+			transient: false,
+			contains:  "status 400",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := catenaxStatusError("upsert", tt.status, []byte(tt.body))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if isTransient(err) != tt.transient {
+				t.Errorf("isTransient = %v, want %v", isTransient(err), tt.transient)
+			}
+			if !strings.Contains(err.Error(), tt.contains) {
+				t.Errorf("error %q does not contain %q", err.Error(), tt.contains)
+			}
+		})
+	}
+}
+
+func TestUpsertFailsOverToFallbackEndpoint(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	fallbackHits := 0
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHits++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: time.Now().UnixMilli()})
+	}))
+	defer fallback.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = primary.URL
+	cfg.CatenaxAPIURLFallback = fallback.URL
+	cfg.CatenaxMaxRetries = 0
+	cfg.CatenaxRetryBase = time.Millisecond
+	initBreakers(cfg)
+
+	if _, err := upsertFraudCases(context.Background(), []FraudCases{{CdlId: "a"}}); err != nil {
+		t.Fatalf("upsertFraudCases with fallback: %v", err)
+	}
+	if fallbackHits != 1 {
+		t.Errorf("fallback served %d requests, want 1", fallbackHits)
+	}
+}
+
+func TestUpsertDoesNotFailOverOnClientError(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer primary.Close()
+
+	fallbackHits := 0
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHits++
+	}))
+	defer fallback.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = primary.URL
+	cfg.CatenaxAPIURLFallback = fallback.URL
+	cfg.CatenaxMaxRetries = 0
+	cfg.CatenaxRetryBase = time.Millisecond
+	initBreakers(cfg)
+
+	if _, err := upsertFraudCases(context.Background(), []FraudCases{{CdlId: "a"}}); err == nil {
+		t.Fatal("expected the 400 to be returned as an error")
+	}
+	if fallbackHits != 0 {
+		t.Errorf("fallback served %d requests, want 0 for a client error", fallbackHits)
+	}
+}
+
+func TestCreateUpsertFraudCasesRequestCountryCode(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = "http://catenax.test"
+
+	tests := []struct {
+		name string
+		fc   FraudCases
+		want string
+	}{
+		{
+			name: "both present, bank wins",
+			fc:   FraudCases{BankCountryCode: "DE", BusinessPartnerCountryCode: "FR"},
+			want: "DE",
+		},
+		{
+			name: "only bank",
+			fc:   FraudCases{BankCountryCode: "DE"},
+			want: "DE",
+		},
+		{
+			name: "only business partner",
+			fc:   FraudCases{BusinessPartnerCountryCode: "FR"},
+			want: "FR",
+		},
+		{
+			name: "neither present",
+			fc:   FraudCases{},
+			want: "",
+		},
+		{
+			// An explicit empty string in the JSON decodes identically to an
+			// absent field, so the fallback must treat them the same.
+			name: "empty bank falls back to business partner",
+			fc:   FraudCases{BankCountryCode: "", BusinessPartnerCountryCode: "FR"},
+			want: "FR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := createUpsertFraudCasesRequest(context.Background(), cfg.CatenaxAPIURL, newIdempotencyKey(), []FraudCases{tt.fc})
+			if err != nil {
+				t.Fatalf("createUpsertFraudCasesRequest: %v", err)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("reading request body: %v", err)
+			}
+			var payload UpsertFraudCasesRequest
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatalf("unmarshaling request body: %v", err)
+			}
+			if len(payload.FraudCases) != 1 {
+				t.Fatalf("expected 1 fraud case in body, got %d", len(payload.FraudCases))
+			}
+			if got := payload.FraudCases[0].CountryCode; got != tt.want {
+				t.Errorf("CountryCode = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpsertContentTypeConfigurable(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = "http://catenax.test"
+	cfg.CatenaxContentType = "application/vnd.catenax.v1+json; charset=utf-8"
+
+	req, err := createUpsertFraudCasesRequest(context.Background(), cfg.CatenaxAPIURL, newIdempotencyKey(), []FraudCases{{CdlId: "case-1"}})
+	if err != nil {
+		t.Fatalf("createUpsertFraudCasesRequest: %v", err)
+	}
+	if got := req.Header.Get("Content-Type"); got != cfg.CatenaxContentType {
+		t.Errorf("Content-Type = %q, want %q", got, cfg.CatenaxContentType)
+	}
+}
+
+func TestUpsertAcceptedCountValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		strict  bool
+		wantErr bool
+	}{
+		{
+			name: "matching count passes",
+			body: `{"updatedAt": 1700000000000, "acceptedCount": 2}`,
+		},
+		{
+			name: "mismatch tolerated when not strict",
+			body: `{"updatedAt": 1700000000000, "acceptedCount": 1}`,
+		},
+		{
+			name:    "mismatch fails when strict",
+			body:    `{"updatedAt": 1700000000000, "acceptedCount": 1}`,
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:   "absent count skips the check",
+			body:   `{"updatedAt": 1700000000000}`,
+			strict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cfg = loadConfig()
+			cfg.CatenaxAPIURL = server.URL
+			cfg.CatenaxMaxRetries = 0
+			cfg.CatenaxRetryBase = time.Millisecond
+			cfg.CatenaxCountStrict = tt.strict
+			initBreakers(cfg)
+
+			_, err := upsertFraudCases(context.Background(), []FraudCases{{CdlId: "a"}, {CdlId: "b"}})
+			if tt.wantErr && err == nil {
+				t.Fatal("expected an error for the accepted-count mismatch")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("upsertFraudCases: %v", err)
+			}
+		})
+	}
+}
+
+func TestDateOfAttackSerializationFormats(t *testing.T) {
+	// 2022-07-01T11:00:00Z in epoch millis.
+	const millis = int64(1656673200000)
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = "http://catenax.test"
+
+	marshalOne := func() string {
+		t.Helper()
+		req, err := createUpsertFraudCasesRequest(context.Background(), cfg.CatenaxAPIURL, newIdempotencyKey(), []FraudCases{{CdlId: "case-1", DateOfAttack: millis}})
+		if err != nil {
+			t.Fatalf("createUpsertFraudCasesRequest: %v", err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("reading request body: %v", err)
+		}
+		return string(body)
+	}
+
+	if body := marshalOne(); !strings.Contains(body, `"dateOfAttack":1656673200000`) {
+		t.Errorf("epoch body = %s, want numeric dateOfAttack", body)
+	}
+
+	cfg.CatenaxDateFormat = dateFormatISO8601
+	if body := marshalOne(); !strings.Contains(body, `"dateOfAttack":"2022-07-01T11:00:00Z"`) {
+		t.Errorf("iso8601 body = %s, want RFC3339 dateOfAttack", body)
+	}
+
+	// Decoding accepts both wire formats regardless of the configured one.
+	for _, raw := range []string{`{"dateOfAttack":1656673200000}`, `{"dateOfAttack":"2022-07-01T11:00:00Z"}`} {
+		var fc FraudCaseObj
+		if err := json.Unmarshal([]byte(raw), &fc); err != nil {
+			t.Fatalf("unmarshal %s: %v", raw, err)
+		}
+		if int64(fc.DateOfAttack) != millis {
+			t.Errorf("DateOfAttack from %s = %d, want %d", raw, fc.DateOfAttack, millis)
+		}
+	}
+	// Values above 2^53 must survive the round-trip exactly; typed int64
+	// decoding never goes through float64.
+	var huge FraudCaseObj
+	if err := json.Unmarshal([]byte(`{"dateOfAttack":9007199254740993}`), &huge); err != nil {
+		t.Fatalf("unmarshal large dateOfAttack: %v", err)
+	}
+	if int64(huge.DateOfAttack) != 9007199254740993 {
+		t.Errorf("large DateOfAttack = %d, want 9007199254740993 (precision lost)", huge.DateOfAttack)
+	}
+	var fc FraudCaseObj
+	if err := json.Unmarshal([]byte(`{"dateOfAttack":"yesterday"}`), &fc); err == nil {
+		t.Error("non-RFC3339 dateOfAttack string was accepted")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// FraudCases is a single fraud case as returned by the CDQ API. Status is
+// optional; CDQ uses it to flag soft-deleted records that are still present
+// in the response.
+type FraudCases struct {
+	CdlId                      string `json:"cdlId"`
+	Type                       string `json:"type"`
+	Status                     string `json:"status"`
+	DateOfAttack               int64  `json:"dateOfAttack"`
+	BankCountryCode            string `json:"bankCountryCode"`
+	BusinessPartnerCountryCode string `json:"businessPartnerCountryCode"`
+}
+
+// isInactiveCase reports whether CDQ flagged the case as soft-deleted. The
+// status vocabulary is not formally specified, so every spelling seen in the
+// wild is matched case-insensitively; an absent status means active.
+func isInactiveCase(fc FraudCases) bool {
+	switch strings.ToUpper(fc.Status) {
+	case "DELETED", "INACTIVE", "SOFT_DELETED":
+		return true
+	}
+	return false
+}
+
+// FraudCasesResponse is one page of fraud cases from the CDQ API. In cursor
+// mode NumberOfPages is absent and NextCursor drives the loop instead; an
+// empty NextCursor means the last page.
+type FraudCasesResponse struct {
+	NumberOfPages int          `json:"numberOfPages"`
+	NextCursor    string       `json:"nextCursor"`
+	FraudCases    []FraudCases `json:"fraudCases"`
+}
+
+// cdqLimiter throttles CDQ fetches when CDQ_RATE_LIMIT_RPS is set. It is
+// initialized from config before the consumer starts and may be swapped on
+// SIGHUP, so access goes through cfgMu like the other reloadable state.
+var cdqLimiter *rate.Limiter
+
+func initCdqLimiter(cfg *Config) {
+	var l *rate.Limiter
+	if cfg.CdqRateLimit > 0 {
+		l = rate.NewLimiter(rate.Limit(cfg.CdqRateLimit), 1)
+	}
+	cfgMu.Lock()
+	cdqLimiter = l
+	cfgMu.Unlock()
+}
+
+// cdqLimiterKey carries an optional per-message limiter through the context;
+// publishers with tenant-specific CDQ quotas set it via the
+// x-cdq-rate-limit header and it overrides the global limiter for that
+// message only.
+type cdqLimiterKeyType struct{}
+
+var cdqLimiterKey cdqLimiterKeyType
+
+func withCdqLimiter(ctx context.Context, l *rate.Limiter) context.Context {
+	return context.WithValue(ctx, cdqLimiterKey, l)
+}
+
+// cdqLimiterFrom returns the message's limiter override, or the global one.
+// May be nil when neither is configured.
+func cdqLimiterFrom(ctx context.Context) *rate.Limiter {
+	if l, ok := ctx.Value(cdqLimiterKey).(*rate.Limiter); ok {
+		return l
+	}
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cdqLimiter
+}
+
+func createGetFraudCasesRequest(ctx context.Context, apiKey string, page int) (*http.Request, error) {
+	url := fmt.Sprintf("%s/fraudcases", cfg.CdqAPIURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-KEY", apiKey)
+	q := req.URL.Query()
+	q.Set("page", strconv.Itoa(page))
+	q.Set("pageSize", strconv.Itoa(effectivePageSize(ctx)))
+	req.URL.RawQuery = q.Encode()
+	return req, nil
+}
+
+// createGetFraudCasesCursorRequest builds the cursor-mode fetch request; an
+// empty cursor requests the first page.
+func createGetFraudCasesCursorRequest(ctx context.Context, apiKey, cursor string) (*http.Request, error) {
+	url := fmt.Sprintf("%s/fraudcases", cfg.CdqAPIURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-KEY", apiKey)
+	q := req.URL.Query()
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	q.Set("pageSize", strconv.Itoa(effectivePageSize(ctx)))
+	req.URL.RawQuery = q.Encode()
+	return req, nil
+}
+
+// fetchFraudCases retrieves one page of fraud cases from CDQ, retrying
+// transient failures per the CDQ retry configuration.
+func fetchFraudCases(ctx context.Context, apiKey string, page int) (*FraudCasesResponse, error) {
+	return fetchWithRetries(ctx, fmt.Sprintf("page %d", page), func(ctx context.Context) (*http.Request, error) {
+		return createGetFraudCasesRequest(ctx, apiKey, page)
+	})
+}
+
+// fetchFraudCasesCursor is the cursor-mode equivalent of fetchFraudCases.
+func fetchFraudCasesCursor(ctx context.Context, apiKey, cursor string) (*FraudCasesResponse, error) {
+	return fetchWithRetries(ctx, "cursor page", func(ctx context.Context) (*http.Request, error) {
+		return createGetFraudCasesCursorRequest(ctx, apiKey, cursor)
+	})
+}
+
+// errStopStreaming lets a stream callback end the stream early (e.g. the
+// case cap was reached) without surfacing an error to the caller.
+var errStopStreaming = errors.New("stop streaming")
+
+// fetchFraudCasesStream handles the newer CDQ endpoint that streams every
+// case as one JSON array with no paging metadata. Cases are decoded one at a
+// time and handed to processBatch in CDQ_PAGE_SIZE chunks, keeping memory
+// bounded regardless of dataset size. The stream is a single request and is
+// not retried mid-read — replaying a half-consumed stream would
+// double-process the cases already handed out.
+func fetchFraudCasesStream(ctx context.Context, apiKey string, processBatch func([]FraudCases) error) error {
+	if err := waitCdqTurn(ctx); err != nil {
+		return err
+	}
+	if err := cdqBreaker.allow(); err != nil {
+		return err
+	}
+	if err := spendAPICall(ctx); err != nil {
+		return err
+	}
+	if err := spendDailyBudget(); err != nil {
+		return err
+	}
+	err := doFetchFraudCasesStream(ctx, apiKey, processBatch)
+	cdqBreaker.record(err)
+	return err
+}
+
+func doFetchFraudCasesStream(ctx context.Context, apiKey string, processBatch func([]FraudCases) error) error {
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, stageFetch))
+	defer cancel()
+
+	url := fmt.Sprintf("%s/fraudcases/stream", cfg.CdqAPIURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-KEY", apiKey)
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	observeRequest(ctx, "cdq_fetch", url, start, cfg.CdqSlowThreshold)
+	if err != nil {
+		return wrapRequestError("cdq_fetch", "CDQ stream request", err)
+	}
+	defer resp.Body.Close()
+	recordStatus("cdq_fetch", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if retryableStatus(resp.StatusCode) {
+			return transient(statusReason(resp.StatusCode), "CDQ returned status %d for stream: %s", resp.StatusCode, body)
+		}
+		return fmt.Errorf("CDQ returned status %d for stream: %s", resp.StatusCode, body)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	tok, err := dec.Token()
+	if err != nil {
+		return transient("network", "reading CDQ stream failed: %w", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("CDQ stream did not start with a JSON array, got %v", tok)
+	}
+
+	var batch []FraudCases
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := processBatch(batch)
+		batch = nil
+		return err
+	}
+	for dec.More() {
+		var fc FraudCases
+		if err := dec.Decode(&fc); err != nil {
+			return transient("network", "decoding CDQ stream failed: %w", err)
+		}
+		batch = append(batch, fc)
+		if len(batch) >= pageSize() {
+			if err := flush(); err != nil {
+				if errors.Is(err, errStopStreaming) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil && !errors.Is(err, errStopStreaming) {
+		return err
+	}
+	return nil
+}
+
+func fetchWithRetries(ctx context.Context, what string, makeReq func(context.Context) (*http.Request, error)) (*FraudCasesResponse, error) {
+	var response *FraudCasesResponse
+	err := withRetries("cdq_fetch", cfg.CdqMaxRetries, cfg.CdqRetryBase, func() error {
+		if err := waitCdqTurn(ctx); err != nil {
+			return err
+		}
+		if err := cdqBreaker.allow(); err != nil {
+			return err
+		}
+		if err := spendAPICall(ctx); err != nil {
+			return err
+		}
+		if err := spendDailyBudget(); err != nil {
+			return err
+		}
+		var err error
+		start := time.Now()
+		response, err = doFetchFraudCases(ctx, what, makeReq)
+		if sizer := pageSizerFrom(ctx); sizer != nil {
+			sizer.observe(time.Since(start), err)
+			log.WithFields(log.Fields{"what": what, "pageSize": sizer.current()}).Debug("Adaptive page size updated")
+		}
+		cdqBreaker.record(err)
+		return err
+	})
+	return response, err
+}
+
+func doFetchFraudCases(ctx context.Context, what string, makeReq func(context.Context) (*http.Request, error)) (*FraudCasesResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, stageFetch))
+	defer cancel()
+
+	req, err := makeReq(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	observeRequest(ctx, "cdq_fetch", req.URL.String(), start, cfg.CdqSlowThreshold)
+	if err != nil {
+		return nil, wrapRequestError("cdq_fetch", fmt.Sprintf("CDQ request for %s", what), err)
+	}
+	defer resp.Body.Close()
+	recordStatus("cdq_fetch", resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transient("network", "reading CDQ response for %s failed: %w", what, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if retryableStatus(resp.StatusCode) {
+			return nil, transient(statusReason(resp.StatusCode), "CDQ returned status %d for %s: %s", resp.StatusCode, what, body)
+		}
+		return nil, fmt.Errorf("CDQ returned status %d for %s: %s", resp.StatusCode, what, body)
+	}
+
+	if err := ensureJSONResponse(resp, body); err != nil {
+		return nil, fmt.Errorf("CDQ response for %s: %w", what, err)
+	}
+
+	// Cursor mode has no numberOfPages; the cursor drives the loop instead.
+	requiredFields := []string{"fraudCases"}
+	if cfg.CdqPagination != cdqPaginationCursor {
+		requiredFields = append(requiredFields, "numberOfPages")
+	}
+	if err := requireResponseFields(body, fmt.Sprintf("CDQ response for %s", what), requiredFields...); err != nil {
+		return nil, err
+	}
+
+	var response FraudCasesResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		// A 200 with unparseable JSON is usually a proxy truncating the body
+		// mid-flight, which a retry fixes; genuinely malformed JSON fails every
+		// attempt and exhausts the retry budget instead. The raw body goes to
+		// the debug log so the broken response can be inspected.
+		log.WithField("body", truncateBody(body, cfg.DebugBodyMaxLen)).Debug("Unparseable CDQ response body")
+		return nil, transient("bad_json", "unmarshaling CDQ response for %s failed: %w", what, err)
+	}
+
+	// Per-page data-volume telemetry; deliberately unlabeled to keep
+	// cardinality down across tenants.
+	cdqPageResponseBytes.Observe(float64(len(body)))
+	cdqPageCaseCount.Observe(float64(len(response.FraudCases)))
+	return &response, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper for canned
+// responses via setTestTransport.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func cannedResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestFetchFraudCasesViaInjectedTransport(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqAPIURL = "http://cdq.test"
+	cfg.CdqMaxRetries = 0
+	cfg.CdqRetryBase = time.Millisecond
+	initCdqLimiter(cfg)
+	initBreakers(cfg)
+
+	var captured *http.Request
+	restore := setTestTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		captured = r
+		return cannedResponse(http.StatusOK, `{"numberOfPages":1,"fraudCases":[{"cdlId":"a"}]}`), nil
+	}))
+	defer restore()
+
+	response, err := fetchFraudCases(context.Background(), "test-key", 1)
+	if err != nil {
+		t.Fatalf("fetchFraudCases: %v", err)
+	}
+	if len(response.FraudCases) != 1 || response.FraudCases[0].CdlId != "a" {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if got := captured.Header.Get("X-API-KEY"); got != "test-key" {
+		t.Errorf("X-API-KEY = %q, want %q", got, "test-key")
+	}
+	if got := captured.URL.Query().Get("page"); got != "1" {
+		t.Errorf("page query = %q, want %q", got, "1")
+	}
+}
+
+func TestFetchFraudCasesClassifies429(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqAPIURL = "http://cdq.test"
+	cfg.CdqMaxRetries = 0
+	cfg.CdqRetryBase = time.Millisecond
+	initCdqLimiter(cfg)
+	initBreakers(cfg)
+
+	restore := setTestTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return cannedResponse(http.StatusTooManyRequests, `{"error":"slow down"}`), nil
+	}))
+	defer restore()
+
+	_, err := fetchFraudCases(context.Background(), "test-key", 1)
+	if err == nil {
+		t.Fatal("expected an error for a 429 response")
+	}
+	if !isTransient(err) {
+		t.Error("429 should be transient")
+	}
+	if got := transientReason(err); got != "http_429" {
+		t.Errorf("transientReason = %q, want %q", got, "http_429")
+	}
+}
+
+func TestTruncatedJSONRetriesAndSucceeds(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqAPIURL = "http://cdq.test"
+	cfg.CdqMaxRetries = 1
+	cfg.CdqRetryBase = time.Millisecond
+	initCdqLimiter(cfg)
+	initBreakers(cfg)
+
+	attempts := 0
+	restore := setTestTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			// A flaky proxy cut the body off mid-object.
+			return cannedResponse(http.StatusOK, `{"numberOfPages":1,"fraudCases":[{"cdl`), nil
+		}
+		return cannedResponse(http.StatusOK, `{"numberOfPages":1,"fraudCases":[{"cdlId":"a"}]}`), nil
+	}))
+	defer restore()
+
+	response, err := fetchFraudCases(context.Background(), "test-key", 1)
+	if err != nil {
+		t.Fatalf("fetchFraudCases after truncated first attempt: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if len(response.FraudCases) != 1 {
+		t.Errorf("unexpected response %+v", response)
+	}
+}
+
+func TestConsistentlyMalformedJSONExhaustsRetries(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqAPIURL = "http://cdq.test"
+	cfg.CdqMaxRetries = 1
+	cfg.CdqRetryBase = time.Millisecond
+	initCdqLimiter(cfg)
+	initBreakers(cfg)
+
+	attempts := 0
+	restore := setTestTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		return cannedResponse(http.StatusOK, `not json at all`), nil
+	}))
+	defer restore()
+
+	_, err := fetchFraudCases(context.Background(), "test-key", 1)
+	if err == nil {
+		t.Fatal("expected an error for consistently malformed JSON")
+	}
+	if !isTransient(err) {
+		t.Errorf("expected a transient classification so the message is requeued, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want retry budget used (2)", attempts)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startCertExpiryCheck periodically inspects the TLS certificates of the CDQ
+// and Catenax endpoints and warns when one is within CERT_EXPIRY_WARN_DAYS of
+// expiry. An expired upstream cert takes every sync down at once; the check
+// buys lead time to escalate to the owning team before that happens.
+// Disabled unless CERT_EXPIRY_CHECK_ENABLED is set.
+func startCertExpiryCheck(ctx context.Context, cfg *Config) {
+	if !cfg.CertExpiryCheckEnabled {
+		return
+	}
+	endpoints := map[string]string{
+		"cdq":     cfg.CdqAPIURL,
+		"catenax": cfg.CatenaxAPIURL,
+	}
+	if cfg.CatenaxAPIURLFallback != "" {
+		endpoints["catenax_fallback"] = cfg.CatenaxAPIURLFallback
+	}
+	warnWithin := time.Duration(cfg.CertExpiryWarnDays) * 24 * time.Hour
+	go func() {
+		ticker := time.NewTicker(cfg.CertExpiryCheckInterval)
+		defer ticker.Stop()
+		for {
+			for endpoint, rawURL := range endpoints {
+				checkCertExpiry(endpoint, rawURL, warnWithin)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkCertExpiry inspects one endpoint's certificate and updates the expiry
+// gauge, warning when the remaining lifetime is below the threshold. A failed
+// check only warns — the endpoint may be unreachable for reasons the real
+// request path already reports.
+func checkCertExpiry(endpoint, rawURL string, warnWithin time.Duration) {
+	expiry, err := peerCertExpiry(rawURL)
+	if err != nil {
+		log.WithError(err).WithField("endpoint", endpoint).Warn("Certificate expiry check failed")
+		return
+	}
+	left := time.Until(expiry)
+	certExpirySeconds.WithLabelValues(endpoint).Set(left.Seconds())
+	if left < warnWithin {
+		log.WithFields(log.Fields{
+			"endpoint":  endpoint,
+			"notAfter":  expiry.Format(time.RFC3339),
+			"remaining": left.Round(time.Hour).String(),
+		}).Warn("Endpoint TLS certificate is close to expiry")
+	}
+}
+
+// peerCertExpiry connects to the endpoint and returns the leaf certificate's
+// NotAfter. Verification is deliberately skipped: the point is to read the
+// expiry of whatever cert the server presents, valid or not — the real
+// request path still verifies as usual.
+func peerCertExpiry(rawURL string) (time.Time, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if u.Scheme != "https" {
+		return time.Time{}, fmt.Errorf("%q is not an https endpoint", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("no peer certificates presented by %s", host)
+	}
+	return certs[0].NotAfter, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPeerCertExpiryReadsLeafCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	expiry, err := peerCertExpiry(server.URL)
+	if err != nil {
+		t.Fatalf("peerCertExpiry: %v", err)
+	}
+	want := server.Certificate().NotAfter
+	if !expiry.Equal(want) {
+		t.Errorf("expiry = %v, want %v", expiry, want)
+	}
+}
+
+func TestPeerCertExpiryRejectsPlainHTTP(t *testing.T) {
+	if _, err := peerCertExpiry("http://example.invalid"); err == nil {
+		t.Fatal("expected an error for a non-https endpoint")
+	}
+}
+
+func TestCheckCertExpirySetsGauge(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	checkCertExpiry("cdq", server.URL, 24*time.Hour)
+	left := testutil.ToFloat64(certExpirySeconds.WithLabelValues("cdq"))
+	if left <= 0 {
+		t.Errorf("cert expiry gauge = %v, want a positive remaining lifetime", left)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// coalescer collapses bursts of messages for the same tenant into a single
+// sync. An upstream change storm can publish dozens of trigger messages for
+// one key within seconds; each sync fetches the tenant's full dataset, so
+// running the first after a short delay covers everything the rest would
+// have done.
+type coalescer struct {
+	window time.Duration
+	mu     sync.Mutex
+	open   map[string]struct{}
+}
+
+// messageCoalescer is nil unless COALESCE_WINDOW_MS is set.
+var messageCoalescer *coalescer
+
+func initCoalescer(cfg *Config) {
+	if cfg.CoalesceWindow <= 0 {
+		return
+	}
+	messageCoalescer = &coalescer{
+		window: cfg.CoalesceWindow,
+		open:   make(map[string]struct{}),
+	}
+}
+
+// offer routes one delivery through the coalescing buffer. The first
+// delivery for a key opens a window and runs process once it closes; every
+// delivery for the same key that arrives inside the window is acknowledged
+// immediately instead. Shutdown cuts the wait short — process then sees the
+// cancelled context and hands the message back to the broker as usual.
+func (c *coalescer) offer(ctx context.Context, msg amqp.Delivery, key string, process func(amqp.Delivery)) {
+	c.mu.Lock()
+	if _, open := c.open[key]; open {
+		c.mu.Unlock()
+		messagesCoalesced.Inc()
+		log.WithField("tenant", key).Debug("Coalescing duplicate message inside the window")
+		ackDelivery(msg)
+		return
+	}
+	c.open[key] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.window):
+		}
+		c.mu.Lock()
+		delete(c.open, key)
+		c.mu.Unlock()
+		process(msg)
+	}()
+}
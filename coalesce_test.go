@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestCoalescerCollapsesBurstIntoOneSync(t *testing.T) {
+	c := &coalescer{window: 50 * time.Millisecond, open: make(map[string]struct{})}
+	ack := &fakeAcknowledger{}
+
+	var mu sync.Mutex
+	processed := 0
+	done := make(chan struct{})
+	process := func(amqp.Delivery) {
+		mu.Lock()
+		processed++
+		mu.Unlock()
+		close(done)
+	}
+
+	c.offer(context.Background(), amqp.Delivery{Acknowledger: ack}, "tenant-a", process)
+	// Three more messages for the same key land inside the window.
+	for i := 0; i < 3; i++ {
+		c.offer(context.Background(), amqp.Delivery{Acknowledger: ack}, "tenant-a", process)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("leader was never processed")
+	}
+	mu.Lock()
+	if processed != 1 {
+		t.Errorf("processed %d syncs, want 1", processed)
+	}
+	mu.Unlock()
+	ack.mu.Lock()
+	if ack.acks != 3 {
+		t.Errorf("acked %d duplicates, want 3", ack.acks)
+	}
+	ack.mu.Unlock()
+}
+
+func TestCoalescerKeepsDistinctKeysApart(t *testing.T) {
+	c := &coalescer{window: 10 * time.Millisecond, open: make(map[string]struct{})}
+	ack := &fakeAcknowledger{}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	processed := 0
+	wg.Add(2)
+	process := func(amqp.Delivery) {
+		mu.Lock()
+		processed++
+		mu.Unlock()
+		wg.Done()
+	}
+
+	c.offer(context.Background(), amqp.Delivery{Acknowledger: ack}, "tenant-a", process)
+	c.offer(context.Background(), amqp.Delivery{Acknowledger: ack}, "tenant-b", process)
+	wg.Wait()
+
+	if processed != 2 {
+		t.Errorf("processed %d syncs, want 2 (different keys must not coalesce)", processed)
+	}
+	if ack.acks != 0 {
+		t.Errorf("acked %d messages, want 0", ack.acks)
+	}
+}
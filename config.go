@@ -0,0 +1,853 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Delete modes: cutoff deletes everything older than the sync's oldest
+// updatedAt; explicit deletes only the CdlIds that vanished since the
+// previous sync (requires persisted state, see idstore.go).
+const (
+	deleteModeCutoff   = "cutoff"
+	deleteModeExplicit = "explicit"
+)
+
+// CDQ pagination modes: page uses numberOfPages and a page parameter (the
+// historical behavior); cursor follows nextCursor tokens until exhausted.
+const (
+	cdqPaginationPage   = "page"
+	cdqPaginationCursor = "cursor"
+)
+
+// CDQ response modes: paged expects page/cursor responses with metadata;
+// stream expects one response carrying every case as a streamed JSON array.
+const (
+	cdqResponseModePaged  = "paged"
+	cdqResponseModeStream = "stream"
+)
+
+// Delivery semantics: at-least-once (default) acks after processing, so a
+// crash redelivers the message and work may repeat; at-most-once acks on
+// receipt, so a crash loses the message and work never repeats. Pick the
+// failure you can live with.
+const (
+	semanticsAtLeastOnce = "at-least-once"
+	semanticsAtMostOnce  = "at-most-once"
+)
+
+// Queue types: classic is the historical default; quorum opts the declared
+// queue into RabbitMQ's replicated quorum queues. Quorum queues track
+// redeliveries in the x-delivery-count header, which RMQ_MAX_DELIVERIES
+// builds on.
+const (
+	queueTypeClassic = "classic"
+	queueTypeQuorum  = "quorum"
+)
+
+// Catenax date formats: how dateOfAttack is serialized in upsert bodies —
+// epoch millis (the historical format) or an RFC3339 string for the newer
+// Catenax schema.
+const (
+	dateFormatEpoch   = "epoch"
+	dateFormatISO8601 = "iso8601"
+)
+
+// Sync batch id modes: where the per-message batch id is attached to upsert
+// requests — a header, a field on every case, or not at all.
+const (
+	batchIDModeHeader = "header"
+	batchIDModeField  = "field"
+	batchIDModeOff    = "off"
+)
+
+// Batch key policies: how a JSON-array-of-keys message behaves when some of
+// its keys fail — all_or_nothing surfaces the first failure so the whole
+// message is redelivered, best_effort finishes the remaining keys and only
+// fails the message when every key failed.
+const (
+	batchPolicyAllOrNothing = "all_or_nothing"
+	batchPolicyBestEffort   = "best_effort"
+)
+
+// Log formats: json is the default and what the current pipeline ingests;
+// logfmt (key=value pairs) feeds older pipelines; text is the human-readable
+// colored output for local runs. All three carry the same structured fields.
+const (
+	logFormatJSON   = "json"
+	logFormatLogfmt = "logfmt"
+	logFormatText   = "text"
+)
+
+// Telemetry backends: otlp is the default, meaning the existing behavior —
+// W3C trace ids propagated as-is for exemplars and external collectors; gcp
+// additionally stamps logs with the Cloud Logging trace-correlation fields so
+// they appear inline in the Cloud Trace viewer.
+const (
+	telemetryBackendOTLP = "otlp"
+	telemetryBackendGCP  = "gcp"
+)
+
+// Run modes: consume processes messages from RabbitMQ (the normal case);
+// replay reads message bodies from REPLAY_FILE and exits; replay-failed
+// re-attempts the upsert of cases recorded in FAILED_CASES_PATH and exits.
+const (
+	runModeConsume      = "consume"
+	runModeReplay       = "replay"
+	runModeReplayFailed = "replay-failed"
+)
+
+// Config holds all runtime settings for the worker. It is populated once at
+// startup from environment variables; see the README for the full list.
+type Config struct {
+	AmqpURL       string
+	QueueName     string
+	PrefetchCount int
+	KeyHeader     string
+	ConsumerTag   string
+
+	ResultExchange   string
+	ResultRoutingKey string
+
+	LineageExchange   string
+	LineageRoutingKey string
+	LineageChunkSize  int
+
+	QueueType         string
+	MaxDeliveries     int
+	DeliverySemantics string
+
+	QueueDepthHighWater int
+	QueueDepthInterval  time.Duration
+	QueueDepthFailReady bool
+
+	ReadinessRequireFirstMessage bool
+
+	BatchKeyPolicy string
+
+	MaxConcurrentMessages int
+	MaxConcurrentPerKey   int
+
+	MemoryGuardEnabled      bool
+	MemoryGuardLimitMB      int
+	MemoryGuardThresholdPct float64
+	MemoryGuardInterval     time.Duration
+
+	SingleActiveConsumer bool
+	ExclusiveConsumer    bool
+	MessageSchemaFile    string
+
+	CdqAPIURL       string
+	CdqAPIKeyFile   string
+	CdqPageSize     int
+	CdqMaxRetries   int
+	CdqRetryBase    time.Duration
+	CdqRateLimit    float64
+	CdqPagination   string
+	CdqResponseMode string
+	RateLimiterURL  string
+
+	CdqDailyCallBudget int
+	CdqBudgetResetHour int
+	CdqBudgetStateFile string
+
+	CdqBestEffortPages  bool
+	CdqFetchConcurrency int
+	CdqSkipInactive     bool
+	CdqRetryEmptyPages  bool
+
+	CdqPageWarnThreshold int
+	CdqPageWarnDeltaPct  float64
+
+	CdqAdaptivePageSize bool
+	CdqPageSizeMin      int
+	CdqPageSizeMax      int
+	CdqAdaptiveSlow     time.Duration
+
+	CdqSlowThreshold     time.Duration
+	CatenaxSlowThreshold time.Duration
+
+	CertExpiryCheckEnabled  bool
+	CertExpiryWarnDays      int
+	CertExpiryCheckInterval time.Duration
+
+	CatenaxAPIURL              string
+	CatenaxAPIURLFallback      string
+	CatenaxDeleteURL           string
+	AllowSplitEndpoints        bool
+	CatenaxRegions             map[string]string
+	CatenaxBatchSize           int
+	CatenaxMaxRetries          int
+	CatenaxRetryBase           time.Duration
+	CatenaxDeleteTimeout       time.Duration
+	CatenaxDeleteMaxRetries    int
+	CatenaxMaxConcurrentWrites int
+	CatenaxBatchConcurrency    int
+	CatenaxContentType         string
+	CatenaxCountStrict         bool
+	CatenaxDateFormat          string
+	TransformProfile           string
+	SyncBatchIDMode            string
+
+	ClockSkewWarn      time.Duration
+	CutoffFutureMargin time.Duration
+
+	CircuitFailureThreshold int
+	CircuitCooldown         time.Duration
+
+	RequestTimeout           time.Duration
+	TimeoutJitterPct         float64
+	DryRun                   bool
+	DiffMode                 bool
+	StrictResponseValidation bool
+	InsecureSkipTLSVerify    bool
+	MaxCasesPerMessage       int
+	MaxAPICallsPerMessage    int
+	MessageBudget            time.Duration
+	DeleteOnEmpty            bool
+	DeleteMode               string
+	DeleteStateDir           string
+	FingerprintStateDir      string
+
+	AuditLogPath     string
+	AuditLogMaxBytes int
+	FailedCasesPath  string
+
+	LogLevel          string
+	LogFormat         string
+	DebugBodyMaxLen   int
+	SentryDSN         string
+	SentryEnvironment string
+
+	TraceExemplars   bool
+	TelemetryBackend string
+	GcpProjectID     string
+
+	EnableTypeMetrics bool
+	CaseTypeAllowlist map[string]bool
+
+	HealthPort      int
+	AdminToken      string
+	ErrorBufferSize int
+	PushgatewayURL  string
+	PushInterval    time.Duration
+
+	StartupWait     time.Duration
+	StartupPingAPIs bool
+
+	RunMode    string
+	ReplayFile string
+
+	DedupTTL        time.Duration
+	DedupMaxEntries int
+
+	CoalesceWindow time.Duration
+
+	OAuthTokenURL     string
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthScope        string
+}
+
+func loadConfig() *Config {
+	return &Config{
+		AmqpURL:       amqpURLFromEnv(),
+		QueueName:     os.Getenv("RMQ_QUEUE_NAME"),
+		PrefetchCount: getEnvInt("RMQ_PREFETCH_COUNT", 1),
+		KeyHeader:     os.Getenv("RMQ_KEY_HEADER"),
+		ConsumerTag:   os.Getenv("RMQ_CONSUMER_TAG"),
+
+		ResultExchange:   os.Getenv("RMQ_RESULT_EXCHANGE"),
+		ResultRoutingKey: getEnv("RMQ_RESULT_ROUTING_KEY", "sync.completed"),
+
+		LineageExchange:   os.Getenv("RMQ_LINEAGE_EXCHANGE"),
+		LineageRoutingKey: getEnv("RMQ_LINEAGE_ROUTING_KEY", "sync.lineage"),
+		LineageChunkSize:  getEnvInt("LINEAGE_CHUNK_SIZE", 1000),
+
+		QueueType:         getEnv("RMQ_QUEUE_TYPE", queueTypeClassic),
+		MaxDeliveries:     getEnvInt("RMQ_MAX_DELIVERIES", 0),
+		DeliverySemantics: getEnv("DELIVERY_SEMANTICS", semanticsAtLeastOnce),
+
+		QueueDepthHighWater: getEnvInt("QUEUE_DEPTH_HIGH_WATERMARK", 0),
+		QueueDepthInterval:  getEnvDuration("QUEUE_DEPTH_INTERVAL_MS", 30*time.Second),
+		QueueDepthFailReady: getEnvBool("QUEUE_DEPTH_FAIL_READY", false),
+
+		ReadinessRequireFirstMessage: getEnvBool("READINESS_REQUIRE_FIRST_MESSAGE", false),
+
+		BatchKeyPolicy: getEnv("BATCH_KEY_POLICY", batchPolicyAllOrNothing),
+
+		MaxConcurrentMessages: getEnvInt("MAX_CONCURRENT_MESSAGES", 1),
+		MaxConcurrentPerKey:   getEnvInt("MAX_CONCURRENT_PER_KEY", 1),
+
+		MemoryGuardEnabled:      getEnvBool("MEMORY_GUARD_ENABLED", false),
+		MemoryGuardLimitMB:      getEnvInt("MEMORY_GUARD_LIMIT_MB", 0),
+		MemoryGuardThresholdPct: getEnvFloat("MEMORY_GUARD_THRESHOLD_PCT", 80),
+		MemoryGuardInterval:     getEnvDuration("MEMORY_GUARD_INTERVAL_MS", 10*time.Second),
+
+		SingleActiveConsumer: getEnvBool("RMQ_SINGLE_ACTIVE", false),
+		ExclusiveConsumer:    getEnvBool("RMQ_EXCLUSIVE", false),
+		MessageSchemaFile:    os.Getenv("MESSAGE_SCHEMA_FILE"),
+
+		CdqAPIURL:       os.Getenv("CDQ_API_URL"),
+		CdqAPIKeyFile:   os.Getenv("CDQ_API_KEY_FILE"),
+		CdqPageSize:     getEnvInt("CDQ_PAGE_SIZE", 500),
+		CdqMaxRetries:   getEnvInt("CDQ_MAX_RETRIES", 3),
+		CdqRetryBase:    getEnvDuration("CDQ_RETRY_BASE_MS", 500*time.Millisecond),
+		CdqRateLimit:    getEnvFloat("CDQ_RATE_LIMIT_RPS", 0),
+		CdqPagination:   getEnv("CDQ_PAGINATION", cdqPaginationPage),
+		CdqResponseMode: getEnv("CDQ_RESPONSE_MODE", cdqResponseModePaged),
+		RateLimiterURL:  os.Getenv("RATE_LIMITER_URL"),
+
+		CdqDailyCallBudget: getEnvInt("CDQ_DAILY_CALL_BUDGET", 0),
+		CdqBudgetResetHour: getEnvInt("CDQ_BUDGET_RESET_HOUR_UTC", 0),
+		CdqBudgetStateFile: os.Getenv("CDQ_BUDGET_STATE_FILE"),
+
+		CdqBestEffortPages:  getEnvBool("CDQ_BEST_EFFORT_PAGES", false),
+		CdqFetchConcurrency: getEnvInt("CDQ_FETCH_CONCURRENCY", 1),
+		CdqSkipInactive:     getEnvBool("CDQ_SKIP_INACTIVE", false),
+		CdqRetryEmptyPages:  getEnvBool("CDQ_RETRY_EMPTY_PAGES", false),
+
+		CdqPageWarnThreshold: getEnvInt("CDQ_PAGE_WARN_THRESHOLD", 0),
+		CdqPageWarnDeltaPct:  getEnvFloat("CDQ_PAGE_WARN_DELTA_PCT", 0),
+
+		CdqAdaptivePageSize: getEnvBool("CDQ_ADAPTIVE_PAGE_SIZE", false),
+		CdqPageSizeMin:      getEnvInt("CDQ_PAGE_SIZE_MIN", 100),
+		CdqPageSizeMax:      getEnvInt("CDQ_PAGE_SIZE_MAX", 2000),
+		CdqAdaptiveSlow:     getEnvDuration("CDQ_ADAPTIVE_SLOW_MS", 2*time.Second),
+
+		CdqSlowThreshold:     getEnvDuration("CDQ_SLOW_MS", 0),
+		CatenaxSlowThreshold: getEnvDuration("CATENAX_SLOW_MS", 0),
+
+		CertExpiryCheckEnabled:  getEnvBool("CERT_EXPIRY_CHECK_ENABLED", false),
+		CertExpiryWarnDays:      getEnvInt("CERT_EXPIRY_WARN_DAYS", 30),
+		CertExpiryCheckInterval: getEnvDuration("CERT_EXPIRY_CHECK_INTERVAL_MS", 6*time.Hour),
+
+		CatenaxAPIURL:              os.Getenv("CATENAX_API_URL"),
+		CatenaxAPIURLFallback:      os.Getenv("CATENAX_API_URL_FALLBACK"),
+		CatenaxDeleteURL:           os.Getenv("CATENAX_DELETE_URL"),
+		AllowSplitEndpoints:        getEnvBool("ALLOW_SPLIT_ENDPOINTS", false),
+		CatenaxRegions:             parseRegionMap(os.Getenv("CATENAX_REGIONS")),
+		CatenaxBatchSize:           getEnvInt("CATENAX_BATCH_SIZE", 1000),
+		CatenaxMaxRetries:          getEnvInt("CATENAX_MAX_RETRIES", 3),
+		CatenaxRetryBase:           getEnvDuration("CATENAX_RETRY_BASE_MS", 500*time.Millisecond),
+		CatenaxDeleteTimeout:       getEnvDuration("CATENAX_DELETE_TIMEOUT_MS", 0),
+		CatenaxDeleteMaxRetries:    getEnvInt("CATENAX_DELETE_MAX_RETRIES", -1),
+		CatenaxMaxConcurrentWrites: getEnvInt("CATENAX_MAX_CONCURRENT_WRITES", 0),
+		CatenaxBatchConcurrency:    getEnvInt("CATENAX_BATCH_CONCURRENCY", 1),
+		CatenaxContentType:         getEnv("CATENAX_CONTENT_TYPE", "application/json"),
+		CatenaxCountStrict:         getEnvBool("CATENAX_COUNT_STRICT", false),
+		CatenaxDateFormat:          getEnv("CATENAX_DATE_FORMAT", dateFormatEpoch),
+		TransformProfile:           getEnv("TRANSFORM_PROFILE", "default"),
+		SyncBatchIDMode:            getEnv("SYNC_BATCH_ID_MODE", batchIDModeHeader),
+
+		ClockSkewWarn:      getEnvDuration("CLOCK_SKEW_WARN_MS", 5*time.Minute),
+		CutoffFutureMargin: getEnvDuration("CUTOFF_FUTURE_MARGIN_MS", 5*time.Minute),
+
+		CircuitFailureThreshold: getEnvInt("CIRCUIT_FAILURE_THRESHOLD", 5),
+		CircuitCooldown:         getEnvDuration("CIRCUIT_COOLDOWN_MS", 30*time.Second),
+
+		RequestTimeout:           getEnvDuration("REQUEST_TIMEOUT_MS", 30*time.Second),
+		TimeoutJitterPct:         getEnvFloat("REQUEST_TIMEOUT_JITTER_PCT", 0),
+		DryRun:                   getEnvBool("DRY_RUN", false),
+		DiffMode:                 getEnvBool("DIFF_MODE", false),
+		StrictResponseValidation: getEnvBool("STRICT_RESPONSE_VALIDATION", false),
+		InsecureSkipTLSVerify:    getEnvBool("INSECURE_SKIP_TLS_VERIFY", false),
+		MaxCasesPerMessage:       getEnvInt("MAX_CASES_PER_MESSAGE", 0),
+		MaxAPICallsPerMessage:    getEnvInt("MAX_API_CALLS_PER_MESSAGE", 0),
+		MessageBudget:            getEnvDuration("MESSAGE_BUDGET_MS", 0),
+		DeleteOnEmpty:            getEnvBool("DELETE_ON_EMPTY", false),
+		DeleteMode:               getEnv("DELETE_MODE", deleteModeCutoff),
+		DeleteStateDir:           os.Getenv("DELETE_STATE_DIR"),
+		FingerprintStateDir:      os.Getenv("FINGERPRINT_STATE_DIR"),
+
+		AuditLogPath:     os.Getenv("AUDIT_LOG_PATH"),
+		AuditLogMaxBytes: getEnvInt("AUDIT_LOG_MAX_BYTES", 0),
+		FailedCasesPath:  os.Getenv("FAILED_CASES_PATH"),
+
+		LogLevel:          getEnv("LOG_LEVEL", "info"),
+		LogFormat:         getEnv("LOG_FORMAT", logFormatJSON),
+		DebugBodyMaxLen:   getEnvInt("DEBUG_BODY_MAX_LEN", 2048),
+		SentryDSN:         os.Getenv("SENTRY_DSN"),
+		SentryEnvironment: getEnv("SENTRY_ENVIRONMENT", "development"),
+
+		TraceExemplars:   getEnvBool("TRACE_EXEMPLARS_ENABLED", false),
+		TelemetryBackend: getEnv("TELEMETRY_BACKEND", telemetryBackendOTLP),
+		GcpProjectID:     os.Getenv("GCP_PROJECT_ID"),
+
+		EnableTypeMetrics: getEnvBool("ENABLE_TYPE_METRICS", false),
+		CaseTypeAllowlist: parseTypeAllowlist(os.Getenv("CASE_TYPE_ALLOWLIST")),
+
+		HealthPort:      getEnvInt("HEALTH_PORT", 8080),
+		AdminToken:      os.Getenv("ADMIN_TOKEN"),
+		ErrorBufferSize: getEnvInt("ERROR_BUFFER_SIZE", 50),
+		PushgatewayURL:  os.Getenv("PUSHGATEWAY_URL"),
+		PushInterval:    getEnvDuration("PUSHGATEWAY_INTERVAL_MS", 0),
+
+		StartupWait:     getEnvDuration("STARTUP_WAIT_MS", 0),
+		StartupPingAPIs: getEnvBool("STARTUP_PING_APIS", false),
+
+		RunMode:    getEnv("RUN_MODE", runModeConsume),
+		ReplayFile: os.Getenv("REPLAY_FILE"),
+
+		DedupTTL:        getEnvDuration("DEDUP_TTL_MS", 0),
+		DedupMaxEntries: getEnvInt("DEDUP_MAX_ENTRIES", 1024),
+
+		CoalesceWindow: getEnvDuration("COALESCE_WINDOW_MS", 0),
+
+		OAuthTokenURL:     os.Getenv("OAUTH_TOKEN_URL"),
+		OAuthClientID:     os.Getenv("OAUTH_CLIENT_ID"),
+		OAuthClientSecret: os.Getenv("OAUTH_CLIENT_SECRET"),
+		OAuthScope:        os.Getenv("OAUTH_SCOPE"),
+	}
+}
+
+// amqpURLFromEnv returns RMQ_AMQP_URL when set; otherwise it assembles the
+// connection string from the component variables (RMQ_HOST, RMQ_PORT,
+// RMQ_USER, RMQ_PASSWORD, RMQ_VHOST) that some secret-management tooling
+// provides separately. Credentials and vhost are URL-encoded here, so
+// passwords with special characters no longer break amqp.Dial the way
+// hand-assembled URLs do.
+func amqpURLFromEnv() string {
+	if v := os.Getenv("RMQ_AMQP_URL"); v != "" {
+		return v
+	}
+	host := os.Getenv("RMQ_HOST")
+	if host == "" {
+		return ""
+	}
+	u := &url.URL{
+		Scheme: "amqp",
+		Host:   fmt.Sprintf("%s:%s", host, getEnv("RMQ_PORT", "5672")),
+	}
+	if user := os.Getenv("RMQ_USER"); user != "" {
+		u.User = url.UserPassword(user, os.Getenv("RMQ_PASSWORD"))
+	}
+	// An absent RMQ_VHOST leaves the path empty, which amqp parses as the
+	// default vhost "/".
+	if vhost := os.Getenv("RMQ_VHOST"); vhost != "" {
+		u.Path = "/" + vhost
+	}
+	return u.String()
+}
+
+// validateEnvVars checks that every environment variable the worker cannot
+// run without is present, and reports all missing ones at once. The required
+// set depends on which features are enabled: optional integrations such as
+// Sentry only pull in their variables when switched on.
+func validateEnvVars() error {
+	required := []string{
+		"CDQ_API_URL",
+		"CATENAX_API_URL",
+	}
+	switch mode := getEnv("RUN_MODE", runModeConsume); mode {
+	case runModeConsume:
+		required = append(required, "RMQ_QUEUE_NAME")
+		// The broker address may arrive as one URL or as RMQ_HOST components
+		// (see amqpURLFromEnv); either satisfies the requirement.
+		if os.Getenv("RMQ_HOST") == "" {
+			required = append(required, "RMQ_AMQP_URL")
+		}
+	case runModeReplay:
+		required = append(required, "REPLAY_FILE")
+	case runModeReplayFailed:
+		required = append(required, "FAILED_CASES_PATH")
+	default:
+		return fmt.Errorf("RUN_MODE must be %q, %q or %q, got %q", runModeConsume, runModeReplay, runModeReplayFailed, mode)
+	}
+	if os.Getenv("OAUTH_TOKEN_URL") != "" {
+		required = append(required, "OAUTH_CLIENT_ID", "OAUTH_CLIENT_SECRET")
+	}
+	var missing []string
+	for _, name := range required {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %v", missing)
+	}
+	if mode := getEnv("CDQ_PAGINATION", cdqPaginationPage); mode != cdqPaginationPage && mode != cdqPaginationCursor {
+		return fmt.Errorf("CDQ_PAGINATION must be %q or %q, got %q", cdqPaginationPage, cdqPaginationCursor, mode)
+	}
+	if mode := getEnv("CDQ_RESPONSE_MODE", cdqResponseModePaged); mode != cdqResponseModePaged && mode != cdqResponseModeStream {
+		return fmt.Errorf("CDQ_RESPONSE_MODE must be %q or %q, got %q", cdqResponseModePaged, cdqResponseModeStream, mode)
+	}
+	switch mode := getEnv("SYNC_BATCH_ID_MODE", batchIDModeHeader); mode {
+	case batchIDModeHeader, batchIDModeField, batchIDModeOff:
+	default:
+		return fmt.Errorf("SYNC_BATCH_ID_MODE must be %q, %q or %q, got %q", batchIDModeHeader, batchIDModeField, batchIDModeOff, mode)
+	}
+	// A mounted key file must be readable and non-empty before the worker
+	// starts consuming; failing the first message would only requeue-loop it.
+	if path := os.Getenv("CDQ_API_KEY_FILE"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading CDQ_API_KEY_FILE: %w", err)
+		}
+		if strings.TrimSpace(string(key)) == "" {
+			return fmt.Errorf("CDQ_API_KEY_FILE %q is empty", path)
+		}
+	}
+	// Some gateways match the upsert Content-Type strictly, so it is
+	// configurable — but it still has to be a parseable media type.
+	if ct := getEnv("CATENAX_CONTENT_TYPE", "application/json"); ct != "" {
+		if _, _, err := mime.ParseMediaType(ct); err != nil {
+			return fmt.Errorf("CATENAX_CONTENT_TYPE %q is not a valid media type: %w", ct, err)
+		}
+	}
+	if getEnvInt("CDQ_DAILY_CALL_BUDGET", 0) > 0 {
+		if os.Getenv("CDQ_BUDGET_STATE_FILE") == "" {
+			return fmt.Errorf("CDQ_BUDGET_STATE_FILE is required when CDQ_DAILY_CALL_BUDGET is set")
+		}
+		if hour := getEnvInt("CDQ_BUDGET_RESET_HOUR_UTC", 0); hour < 0 || hour > 23 {
+			return fmt.Errorf("CDQ_BUDGET_RESET_HOUR_UTC must be between 0 and 23, got %d", hour)
+		}
+	}
+	switch format := getEnv("CATENAX_DATE_FORMAT", dateFormatEpoch); format {
+	case dateFormatEpoch, dateFormatISO8601:
+	default:
+		return fmt.Errorf("CATENAX_DATE_FORMAT must be %q or %q, got %q", dateFormatEpoch, dateFormatISO8601, format)
+	}
+	// The per-type counter only runs against a bounded label set; enabling it
+	// without an allowlist would put every case into "other" and help nobody.
+	if getEnvBool("ENABLE_TYPE_METRICS", false) && os.Getenv("CASE_TYPE_ALLOWLIST") == "" {
+		return fmt.Errorf("CASE_TYPE_ALLOWLIST is required when ENABLE_TYPE_METRICS is set")
+	}
+	switch backend := getEnv("TELEMETRY_BACKEND", telemetryBackendOTLP); backend {
+	case telemetryBackendOTLP, telemetryBackendGCP:
+	default:
+		return fmt.Errorf("TELEMETRY_BACKEND must be %q or %q, got %q", telemetryBackendOTLP, telemetryBackendGCP, backend)
+	}
+	switch format := getEnv("LOG_FORMAT", logFormatJSON); format {
+	case logFormatJSON, logFormatLogfmt, logFormatText:
+	default:
+		return fmt.Errorf("LOG_FORMAT must be %q, %q or %q, got %q", logFormatJSON, logFormatLogfmt, logFormatText, format)
+	}
+	switch sem := getEnv("DELIVERY_SEMANTICS", semanticsAtLeastOnce); sem {
+	case semanticsAtLeastOnce, semanticsAtMostOnce:
+	default:
+		return fmt.Errorf("DELIVERY_SEMANTICS must be %q or %q, got %q", semanticsAtLeastOnce, semanticsAtMostOnce, sem)
+	}
+	switch qt := getEnv("RMQ_QUEUE_TYPE", queueTypeClassic); qt {
+	case queueTypeClassic, queueTypeQuorum:
+	default:
+		return fmt.Errorf("RMQ_QUEUE_TYPE must be %q or %q, got %q", queueTypeClassic, queueTypeQuorum, qt)
+	}
+	switch policy := getEnv("BATCH_KEY_POLICY", batchPolicyAllOrNothing); policy {
+	case batchPolicyAllOrNothing, batchPolicyBestEffort:
+	default:
+		return fmt.Errorf("BATCH_KEY_POLICY must be %q or %q, got %q", batchPolicyAllOrNothing, batchPolicyBestEffort, policy)
+	}
+	switch mode := getEnv("DELETE_MODE", deleteModeCutoff); mode {
+	case deleteModeCutoff:
+	case deleteModeExplicit:
+		if os.Getenv("DELETE_STATE_DIR") == "" {
+			return fmt.Errorf("DELETE_STATE_DIR is required when DELETE_MODE=explicit")
+		}
+	default:
+		return fmt.Errorf("DELETE_MODE must be %q or %q, got %q", deleteModeCutoff, deleteModeExplicit, mode)
+	}
+	return nil
+}
+
+// validateEndpointConsistency guards against CATENAX_DELETE_URL pointing at a
+// different backend than the upsert endpoint: a delete acting on a dataset
+// the upsert did not write would silently wipe good data. A host mismatch is
+// fatal unless ALLOW_SPLIT_ENDPOINTS explicitly accepts it, and either way
+// the decision is logged so the topology is visible at startup.
+func validateEndpointConsistency(cfg *Config) error {
+	if cfg.CatenaxDeleteURL == "" {
+		return nil
+	}
+	deleteURL, err := url.Parse(cfg.CatenaxDeleteURL)
+	if err != nil {
+		return fmt.Errorf("parsing CATENAX_DELETE_URL: %w", err)
+	}
+	upsertURL, err := url.Parse(cfg.CatenaxAPIURL)
+	if err != nil {
+		return fmt.Errorf("parsing CATENAX_API_URL: %w", err)
+	}
+	if deleteURL.Host == upsertURL.Host {
+		return nil
+	}
+	if !cfg.AllowSplitEndpoints {
+		return fmt.Errorf("CATENAX_DELETE_URL host %q differs from CATENAX_API_URL host %q; set ALLOW_SPLIT_ENDPOINTS=true if this split is intentional", deleteURL.Host, upsertURL.Host)
+	}
+	log.WithFields(log.Fields{
+		"deleteHost": deleteURL.Host,
+		"upsertHost": upsertURL.Host,
+	}).Warn("Upsert and delete endpoints target different hosts, allowed by ALLOW_SPLIT_ENDPOINTS")
+	return nil
+}
+
+// parseTypeAllowlist parses CASE_TYPE_ALLOWLIST, a comma-separated list of
+// fraud-case types permitted as metric label values.
+func parseTypeAllowlist(v string) map[string]bool {
+	if v == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// parseRegionMap parses the CATENAX_REGIONS allowlist, a comma-separated
+// list of name=url pairs (e.g. "eu=https://eu.example,us=https://us.example").
+// Messages may only override the Catenax target with a region named here.
+func parseRegionMap(v string) map[string]string {
+	if v == "" {
+		return nil
+	}
+	regions := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		name, url, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || url == "" {
+			failOnError(fmt.Errorf("expected name=url, got %q", pair), "Invalid CATENAX_REGIONS entry", exitConfig)
+		}
+		regions[name] = url
+	}
+	return regions
+}
+
+// redactedConfig flattens the effective configuration for the startup log
+// and the /config endpoint, masking every secret-bearing field. Operators can
+// confirm what a running pod actually loaded without the output leaking
+// credentials.
+func redactedConfig(cfg *Config) map[string]interface{} {
+	regions := make(map[string]string, len(cfg.CatenaxRegions))
+	for name, url := range cfg.CatenaxRegions {
+		regions[name] = redactEndpoint(url)
+	}
+	return map[string]interface{}{
+		"amqpURL":           redactEndpoint(cfg.AmqpURL),
+		"queueName":         cfg.QueueName,
+		"prefetchCount":     cfg.PrefetchCount,
+		"keyHeader":         cfg.KeyHeader,
+		"consumerTag":       cfg.ConsumerTag,
+		"resultExchange":    cfg.ResultExchange,
+		"resultRoutingKey":  cfg.ResultRoutingKey,
+		"lineageExchange":   cfg.LineageExchange,
+		"lineageRoutingKey": cfg.LineageRoutingKey,
+		"lineageChunkSize":  cfg.LineageChunkSize,
+		"batchKeyPolicy":    cfg.BatchKeyPolicy,
+		"queueType":         cfg.QueueType,
+		"maxDeliveries":     cfg.MaxDeliveries,
+		"deliverySemantics": cfg.DeliverySemantics,
+
+		"queueDepthHighWater": cfg.QueueDepthHighWater,
+		"queueDepthInterval":  cfg.QueueDepthInterval.String(),
+		"queueDepthFailReady": cfg.QueueDepthFailReady,
+
+		"readinessRequireFirstMessage": cfg.ReadinessRequireFirstMessage,
+
+		"maxConcurrentMessages": cfg.MaxConcurrentMessages,
+		"maxConcurrentPerKey":   cfg.MaxConcurrentPerKey,
+
+		"memoryGuardEnabled":      cfg.MemoryGuardEnabled,
+		"memoryGuardLimitMB":      cfg.MemoryGuardLimitMB,
+		"memoryGuardThresholdPct": cfg.MemoryGuardThresholdPct,
+		"memoryGuardInterval":     cfg.MemoryGuardInterval.String(),
+
+		"singleActiveConsumer": cfg.SingleActiveConsumer,
+		"exclusiveConsumer":    cfg.ExclusiveConsumer,
+		"messageSchemaFile":    cfg.MessageSchemaFile,
+
+		"cdqAPIURL":           redactEndpoint(cfg.CdqAPIURL),
+		"cdqAPIKeyFile":       cfg.CdqAPIKeyFile,
+		"cdqPageSize":         cfg.CdqPageSize,
+		"cdqMaxRetries":       cfg.CdqMaxRetries,
+		"cdqRetryBase":        cfg.CdqRetryBase.String(),
+		"cdqRateLimit":        cfg.CdqRateLimit,
+		"cdqPagination":       cfg.CdqPagination,
+		"cdqResponseMode":     cfg.CdqResponseMode,
+		"rateLimiterURL":      cfg.RateLimiterURL,
+		"cdqDailyCallBudget":  cfg.CdqDailyCallBudget,
+		"cdqBudgetResetHour":  cfg.CdqBudgetResetHour,
+		"cdqBudgetStateFile":  cfg.CdqBudgetStateFile,
+		"cdqBestEffortPages":  cfg.CdqBestEffortPages,
+		"cdqFetchConcurrency": cfg.CdqFetchConcurrency,
+		"cdqSkipInactive":     cfg.CdqSkipInactive,
+		"cdqRetryEmptyPages":  cfg.CdqRetryEmptyPages,
+
+		"cdqPageWarnThreshold": cfg.CdqPageWarnThreshold,
+		"cdqPageWarnDeltaPct":  cfg.CdqPageWarnDeltaPct,
+		"cdqAdaptivePageSize":  cfg.CdqAdaptivePageSize,
+		"cdqPageSizeMin":       cfg.CdqPageSizeMin,
+		"cdqPageSizeMax":       cfg.CdqPageSizeMax,
+		"cdqAdaptiveSlow":      cfg.CdqAdaptiveSlow.String(),
+
+		"cdqSlowThreshold":     cfg.CdqSlowThreshold.String(),
+		"catenaxSlowThreshold": cfg.CatenaxSlowThreshold.String(),
+
+		"certExpiryCheckEnabled":  cfg.CertExpiryCheckEnabled,
+		"certExpiryWarnDays":      cfg.CertExpiryWarnDays,
+		"certExpiryCheckInterval": cfg.CertExpiryCheckInterval.String(),
+
+		"catenaxAPIURL":              redactEndpoint(cfg.CatenaxAPIURL),
+		"catenaxAPIURLFallback":      redactEndpoint(cfg.CatenaxAPIURLFallback),
+		"catenaxDeleteURL":           redactEndpoint(cfg.CatenaxDeleteURL),
+		"allowSplitEndpoints":        cfg.AllowSplitEndpoints,
+		"catenaxRegions":             regions,
+		"catenaxBatchSize":           cfg.CatenaxBatchSize,
+		"catenaxMaxRetries":          cfg.CatenaxMaxRetries,
+		"catenaxRetryBase":           cfg.CatenaxRetryBase.String(),
+		"catenaxDeleteTimeout":       cfg.CatenaxDeleteTimeout.String(),
+		"catenaxDeleteMaxRetries":    cfg.CatenaxDeleteMaxRetries,
+		"catenaxMaxConcurrentWrites": cfg.CatenaxMaxConcurrentWrites,
+		"catenaxBatchConcurrency":    cfg.CatenaxBatchConcurrency,
+		"catenaxContentType":         cfg.CatenaxContentType,
+		"catenaxCountStrict":         cfg.CatenaxCountStrict,
+		"catenaxDateFormat":          cfg.CatenaxDateFormat,
+		"transformProfile":           cfg.TransformProfile,
+		"syncBatchIDMode":            cfg.SyncBatchIDMode,
+
+		"clockSkewWarn":      cfg.ClockSkewWarn.String(),
+		"cutoffFutureMargin": cfg.CutoffFutureMargin.String(),
+
+		"circuitFailureThreshold": cfg.CircuitFailureThreshold,
+		"circuitCooldown":         cfg.CircuitCooldown.String(),
+
+		"requestTimeout":           cfg.RequestTimeout.String(),
+		"timeoutJitterPct":         cfg.TimeoutJitterPct,
+		"dryRun":                   cfg.DryRun,
+		"diffMode":                 cfg.DiffMode,
+		"strictResponseValidation": cfg.StrictResponseValidation,
+		"insecureSkipTLSVerify":    cfg.InsecureSkipTLSVerify,
+		"maxCasesPerMessage":       cfg.MaxCasesPerMessage,
+		"maxAPICallsPerMessage":    cfg.MaxAPICallsPerMessage,
+		"messageBudget":            cfg.MessageBudget.String(),
+		"deleteOnEmpty":            cfg.DeleteOnEmpty,
+		"deleteMode":               cfg.DeleteMode,
+		"deleteStateDir":           cfg.DeleteStateDir,
+		"fingerprintStateDir":      cfg.FingerprintStateDir,
+
+		"auditLogPath":     cfg.AuditLogPath,
+		"auditLogMaxBytes": cfg.AuditLogMaxBytes,
+		"failedCasesPath":  cfg.FailedCasesPath,
+
+		"logLevel":          cfg.LogLevel,
+		"logFormat":         cfg.LogFormat,
+		"debugBodyMaxLen":   cfg.DebugBodyMaxLen,
+		"sentryDSN":         redactSecret(cfg.SentryDSN),
+		"sentryEnvironment": cfg.SentryEnvironment,
+
+		"traceExemplars":   cfg.TraceExemplars,
+		"telemetryBackend": cfg.TelemetryBackend,
+		"gcpProjectID":     cfg.GcpProjectID,
+
+		"enableTypeMetrics": cfg.EnableTypeMetrics,
+		"caseTypeAllowlist": cfg.CaseTypeAllowlist,
+
+		"healthPort":      cfg.HealthPort,
+		"adminToken":      redactSecret(cfg.AdminToken),
+		"errorBufferSize": cfg.ErrorBufferSize,
+		"pushgatewayURL":  redactEndpoint(cfg.PushgatewayURL),
+		"pushInterval":    cfg.PushInterval.String(),
+
+		"startupWait":     cfg.StartupWait.String(),
+		"startupPingAPIs": cfg.StartupPingAPIs,
+
+		"runMode":    cfg.RunMode,
+		"replayFile": cfg.ReplayFile,
+
+		"dedupTTL":        cfg.DedupTTL.String(),
+		"dedupMaxEntries": cfg.DedupMaxEntries,
+		"coalesceWindow":  cfg.CoalesceWindow.String(),
+
+		"oauthTokenURL":     redactEndpoint(cfg.OAuthTokenURL),
+		"oauthClientID":     cfg.OAuthClientID,
+		"oauthClientSecret": redactSecret(cfg.OAuthClientSecret),
+		"oauthScope":        cfg.OAuthScope,
+	}
+}
+
+// redactEndpoint masks credentials embedded in a URL while keeping scheme,
+// host and path visible.
+func redactEndpoint(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable>"
+	}
+	if u.User != nil {
+		u.User = url.User("<redacted>")
+	}
+	return u.String()
+}
+
+// redactSecret keeps only whether a secret was set at all.
+func redactSecret(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+func getEnv(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		failOnError(err, fmt.Sprintf("Invalid integer value for %s", name), exitConfig)
+	}
+	return n
+}
+
+func getEnvFloat(name string, fallback float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		failOnError(err, fmt.Sprintf("Invalid float value for %s", name), exitConfig)
+	}
+	return f
+}
+
+func getEnvBool(name string, fallback bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		failOnError(err, fmt.Sprintf("Invalid boolean value for %s", name), exitConfig)
+	}
+	return b
+}
+
+// getEnvDuration reads a millisecond-valued environment variable.
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil {
+		failOnError(err, fmt.Sprintf("Invalid millisecond value for %s", name), exitConfig)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dailyBudget caps the total number of CDQ calls the worker makes per day,
+// for tenants whose CDQ contract meters by call volume. The counter rolls
+// over at CDQ_BUDGET_RESET_HOUR_UTC and is persisted to
+// CDQ_BUDGET_STATE_FILE so a restart does not grant a fresh day. When the
+// budget runs out the worker pauses consumption via the pause gate until the
+// reset, so deliveries wait on the broker instead of requeue-looping.
+type dailyBudget struct {
+	mu        sync.Mutex
+	max       int
+	resetHour int
+	path      string
+	spent     int
+	windowEnd time.Time
+	paused    bool
+}
+
+// cdqDailyBudget is set up at startup when CDQ_DAILY_CALL_BUDGET is set.
+var cdqDailyBudget *dailyBudget
+
+// budgetState is the JSON shape persisted to CDQ_BUDGET_STATE_FILE.
+type budgetState struct {
+	Spent     int       `json:"spent"`
+	WindowEnd time.Time `json:"windowEnd"`
+}
+
+func initDailyBudget(cfg *Config) error {
+	if cfg.CdqDailyCallBudget <= 0 {
+		return nil
+	}
+	b := &dailyBudget{
+		max:       cfg.CdqDailyCallBudget,
+		resetHour: cfg.CdqBudgetResetHour,
+		path:      cfg.CdqBudgetStateFile,
+	}
+	b.windowEnd = nextReset(time.Now().UTC(), b.resetHour)
+
+	data, err := os.ReadFile(b.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		var state budgetState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("parsing budget state file %q: %w", b.path, err)
+		}
+		// Adopt the persisted count only when it belongs to the current
+		// window; a file left over from an earlier day means a fresh budget.
+		if state.WindowEnd.Equal(b.windowEnd) {
+			b.spent = state.Spent
+		}
+	}
+
+	cdqDailyBudget = b
+	dailyBudgetSpent.Set(float64(b.spent))
+	log.WithFields(log.Fields{
+		"budget":  b.max,
+		"spent":   b.spent,
+		"resetAt": b.windowEnd.Format(time.RFC3339),
+	}).Info("Daily CDQ call budget enabled")
+	return nil
+}
+
+// nextReset returns the first occurrence of the reset hour (UTC) strictly
+// after now.
+func nextReset(now time.Time, hour int) time.Time {
+	reset := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !reset.After(now) {
+		reset = reset.Add(24 * time.Hour)
+	}
+	return reset
+}
+
+// spendDailyBudget charges one CDQ call against the daily budget, if one is
+// configured.
+func spendDailyBudget() error {
+	if cdqDailyBudget == nil {
+		return nil
+	}
+	return cdqDailyBudget.spend(time.Now().UTC())
+}
+
+// spend consumes one call. The over-budget error is transient so the
+// in-flight message is requeued and picked up again after the reset rather
+// than dropped.
+func (b *dailyBudget) spend(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !now.Before(b.windowEnd) {
+		b.rolloverLocked(now)
+	}
+	b.spent++
+	dailyBudgetSpent.Set(float64(b.spent))
+	if err := b.persistLocked(); err != nil {
+		// Losing one persist is survivable (the count is at most one low
+		// after a crash); losing the sync is not, so only warn.
+		log.WithError(err).Warn("Persisting daily budget state failed")
+	}
+	if b.spent <= b.max {
+		return nil
+	}
+	if !b.paused {
+		b.paused = true
+		dailyBudgetPauses.Inc()
+		log.WithFields(log.Fields{
+			"budget":   b.max,
+			"resumeAt": b.windowEnd.Format(time.RFC3339),
+		}).Warn("Daily CDQ call budget exhausted, pausing consumption until reset")
+		pauser.pause("daily CDQ budget exhausted")
+		time.AfterFunc(time.Until(b.windowEnd), b.resumeAfterReset)
+	}
+	return transient("daily_budget", "daily CDQ call budget of %d exhausted until %s", b.max, b.windowEnd.Format(time.RFC3339))
+}
+
+// rolloverLocked starts a fresh window. Callers hold b.mu.
+func (b *dailyBudget) rolloverLocked(now time.Time) {
+	b.spent = 0
+	b.windowEnd = nextReset(now, b.resetHour)
+	b.paused = false
+}
+
+// resumeAfterReset fires at the window boundary: it rolls the counter over
+// and lifts the pause.
+func (b *dailyBudget) resumeAfterReset() {
+	b.mu.Lock()
+	b.rolloverLocked(time.Now().UTC())
+	dailyBudgetSpent.Set(float64(b.spent))
+	if err := b.persistLocked(); err != nil {
+		log.WithError(err).Warn("Persisting daily budget state failed")
+	}
+	b.mu.Unlock()
+	pauser.resume("daily CDQ budget reset")
+}
+
+// persistLocked saves the counter with write-and-rename so a crash mid-save
+// never leaves a torn state file. Callers hold b.mu.
+func (b *dailyBudget) persistLocked() error {
+	data, err := json.Marshal(budgetState{Spent: b.spent, WindowEnd: b.windowEnd})
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextReset(t *testing.T) {
+	now := time.Date(2024, 5, 10, 14, 30, 0, 0, time.UTC)
+	if got := nextReset(now, 16); !got.Equal(time.Date(2024, 5, 10, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("reset hour later today: got %v", got)
+	}
+	if got := nextReset(now, 3); !got.Equal(time.Date(2024, 5, 11, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("reset hour already passed: got %v", got)
+	}
+	// Exactly on the boundary the next reset is tomorrow, not now.
+	boundary := time.Date(2024, 5, 10, 3, 0, 0, 0, time.UTC)
+	if got := nextReset(boundary, 3); !got.Equal(boundary.Add(24 * time.Hour)) {
+		t.Errorf("reset at the boundary: got %v", got)
+	}
+}
+
+func TestDailyBudgetPausesWhenExhausted(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqDailyCallBudget = 2
+	cfg.CdqBudgetStateFile = filepath.Join(t.TempDir(), "budget.json")
+	if err := initDailyBudget(cfg); err != nil {
+		t.Fatalf("initDailyBudget: %v", err)
+	}
+	defer func() {
+		cdqDailyBudget = nil
+		pauser.resume("test cleanup")
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := spendDailyBudget(); err != nil {
+			t.Fatalf("call %d within budget: %v", i+1, err)
+		}
+	}
+	err := spendDailyBudget()
+	if err == nil {
+		t.Fatal("expected an error once the budget is exhausted")
+	}
+	if !isTransient(err) {
+		t.Errorf("over-budget error must be transient (requeue), got %v", err)
+	}
+	if !pauser.isPaused() {
+		t.Error("consumption not paused after exhausting the budget")
+	}
+}
+
+func TestDailyBudgetSurvivesRestart(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqDailyCallBudget = 10
+	cfg.CdqBudgetStateFile = filepath.Join(t.TempDir(), "budget.json")
+	if err := initDailyBudget(cfg); err != nil {
+		t.Fatalf("initDailyBudget: %v", err)
+	}
+	defer func() { cdqDailyBudget = nil }()
+
+	for i := 0; i < 3; i++ {
+		if err := spendDailyBudget(); err != nil {
+			t.Fatalf("spend: %v", err)
+		}
+	}
+
+	// A restart within the same window must pick the count back up.
+	cdqDailyBudget = nil
+	if err := initDailyBudget(cfg); err != nil {
+		t.Fatalf("re-initDailyBudget: %v", err)
+	}
+	if cdqDailyBudget.spent != 3 {
+		t.Errorf("spent after restart = %d, want 3", cdqDailyBudget.spent)
+	}
+}
+
+func TestDailyBudgetRollsOverAtWindowEnd(t *testing.T) {
+	b := &dailyBudget{max: 1, resetHour: 0, path: filepath.Join(t.TempDir(), "budget.json")}
+	now := time.Date(2024, 5, 10, 23, 0, 0, 0, time.UTC)
+	b.windowEnd = nextReset(now, 0)
+	b.spent = 1
+
+	// The first call of the new day starts a fresh window instead of failing.
+	if err := b.spend(b.windowEnd.Add(time.Minute)); err != nil {
+		t.Fatalf("spend after window end: %v", err)
+	}
+	if b.spent != 1 {
+		t.Errorf("spent after rollover = %d, want 1", b.spent)
+	}
+}
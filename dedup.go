@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// dedupCache remembers recently processed message identities so at-least-once
+// redeliveries within the TTL can be acknowledged without rerunning a full
+// sync. Entries expire after the TTL and the cache is additionally bounded by
+// a maximum entry count, evicting oldest-first, so it can never grow without
+// limit.
+type dedupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]time.Time
+	order   []string
+}
+
+// messageDedup is nil unless DEDUP_TTL_MS is set.
+var messageDedup *dedupCache
+
+func initDedup(cfg *Config) {
+	if cfg.DedupTTL <= 0 {
+		return
+	}
+	messageDedup = &dedupCache{
+		ttl:     cfg.DedupTTL,
+		maxSize: cfg.DedupMaxEntries,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// dedupKey identifies a delivery for duplicate detection: the publisher's
+// MessageId when present, otherwise a hash of the body.
+func dedupKey(msg amqp.Delivery) string {
+	if msg.MessageId != "" {
+		return msg.MessageId
+	}
+	sum := sha256.Sum256(msg.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup reports whether the key was successfully processed within the TTL.
+func (c *dedupCache) lookup(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(time.Now())
+	_, ok := c.entries[key]
+	return ok
+}
+
+// remember records a successfully processed key. Keys are only added after
+// success so a requeued failure is still reprocessed on redelivery.
+func (c *dedupCache) remember(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.prune(now)
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	for len(c.entries) >= c.maxSize && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = now.Add(c.ttl)
+	c.order = append(c.order, key)
+}
+
+// prune drops expired entries from the front of the insertion order. The TTL
+// is uniform, so expiry order matches insertion order.
+func (c *dedupCache) prune(now time.Time) {
+	for len(c.order) > 0 {
+		key := c.order[0]
+		expiry, ok := c.entries[key]
+		if ok && now.Before(expiry) {
+			return
+		}
+		c.order = c.order[1:]
+		delete(c.entries, key)
+	}
+}
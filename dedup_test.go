@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCacheRemembersWithinTTL(t *testing.T) {
+	c := &dedupCache{ttl: time.Minute, maxSize: 10, entries: make(map[string]time.Time)}
+
+	if c.lookup("a") {
+		t.Error("unseen key reported as duplicate")
+	}
+	c.remember("a")
+	if !c.lookup("a") {
+		t.Error("remembered key not reported as duplicate")
+	}
+}
+
+func TestDedupCacheExpiresEntries(t *testing.T) {
+	c := &dedupCache{ttl: time.Millisecond, maxSize: 10, entries: make(map[string]time.Time)}
+
+	c.remember("a")
+	time.Sleep(5 * time.Millisecond)
+	if c.lookup("a") {
+		t.Error("expired key still reported as duplicate")
+	}
+}
+
+func TestDedupCacheEvictsOldestAtCapacity(t *testing.T) {
+	c := &dedupCache{ttl: time.Minute, maxSize: 2, entries: make(map[string]time.Time)}
+
+	c.remember("a")
+	c.remember("b")
+	c.remember("c")
+	if c.lookup("a") {
+		t.Error("oldest key survived eviction")
+	}
+	if !c.lookup("b") || !c.lookup("c") {
+		t.Error("newer keys were evicted")
+	}
+}
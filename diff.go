@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CatenaxCasesResponse is the body of the optional GET /fraudcases endpoint
+// diff mode reads the current Catenax state from.
+type CatenaxCasesResponse struct {
+	FraudCases []FraudCaseObj `json:"fraudCases"`
+}
+
+// fetchCatenaxCases retrieves the current Catenax dataset for diff mode. The
+// GET endpoint is optional; deployments without it answer 404 or 405, which
+// is reported via ok=false so the caller can skip the diff gracefully
+// instead of failing the message.
+func fetchCatenaxCases(ctx context.Context) (cases []FraudCaseObj, ok bool, err error) {
+	if err := spendAPICall(ctx); err != nil {
+		return nil, false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, stageFetch))
+	defer cancel()
+
+	url := fmt.Sprintf("%s/fraudcases", catenaxBase(ctx))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	authorizeRequest(req)
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	observeRequest(ctx, "catenax_fetch", url, start, cfg.CatenaxSlowThreshold)
+	if err != nil {
+		return nil, false, wrapRequestError("catenax_fetch", "Catenax fetch request", err)
+	}
+	defer resp.Body.Close()
+	recordStatus("catenax_fetch", resp.StatusCode)
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, false, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, transient("network", "reading Catenax fetch response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, catenaxStatusError("fetch", resp.StatusCode, body)
+	}
+	var response CatenaxCasesResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.WithField("body", truncateBody(body, cfg.DebugBodyMaxLen)).Debug("Unparseable Catenax fetch response body")
+		return nil, false, transient("bad_json", "unmarshaling Catenax fetch response failed: %w", err)
+	}
+	return response.FraudCases, true, nil
+}
+
+// reportDiff compares the fetched CDQ dataset against the current Catenax
+// state and logs how many cases a real sync would add, update and delete.
+// Nothing is written; the comparison uses the active transform so the
+// "updated" count reflects what would actually be sent.
+func reportDiff(ctx context.Context, cdqCases []FraudCases) error {
+	existing, ok, err := fetchCatenaxCases(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Warn("Catenax deployment has no GET /fraudcases endpoint, skipping diff report")
+		return nil
+	}
+
+	current := make(map[string]FraudCaseObj, len(existing))
+	for _, fc := range existing {
+		fc.SyncBatchID = ""
+		current[fc.CdlId] = fc
+	}
+
+	var adds, updates, unchanged int
+	seen := make(map[string]bool, len(cdqCases))
+	for _, fc := range cdqCases {
+		obj := activeTransform(fc)
+		obj.SyncBatchID = ""
+		seen[obj.CdlId] = true
+		prev, exists := current[obj.CdlId]
+		switch {
+		case !exists:
+			adds++
+		case prev != obj:
+			updates++
+		default:
+			unchanged++
+		}
+	}
+	deletes := 0
+	for id := range current {
+		if !seen[id] {
+			deletes++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"adds":      adds,
+		"updates":   updates,
+		"deletes":   deletes,
+		"unchanged": unchanged,
+		"catenax":   len(existing),
+		"cdq":       len(cdqCases),
+	}).Info("Diff report, no writes performed")
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// errorRecord is one recent failure as shown on /errors. Detail is redacted
+// the same way logged URLs are: no query parameters, no credentials.
+type errorRecord struct {
+	Time      time.Time `json:"time"`
+	MessageID string    `json:"messageId,omitempty"`
+	Stage     string    `json:"stage"`
+	Detail    string    `json:"detail"`
+}
+
+// errorBuffer is a fixed-size ring of the most recent errors, so on-call
+// engineers can inspect failures via the health server without log access.
+type errorBuffer struct {
+	mu      sync.Mutex
+	max     int
+	records []errorRecord
+	next    int
+}
+
+// recentErrors is the process-wide buffer, sized by ERROR_BUFFER_SIZE.
+var recentErrors *errorBuffer
+
+func initErrorBuffer(cfg *Config) {
+	recentErrors = &errorBuffer{max: cfg.ErrorBufferSize}
+}
+
+func (b *errorBuffer) add(messageID, stage string, err error) {
+	if b == nil || b.max <= 0 {
+		return
+	}
+	rec := errorRecord{
+		Time:      time.Now().UTC(),
+		MessageID: messageID,
+		Stage:     stage,
+		Detail:    redactDetail(err.Error()),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.records) < b.max {
+		b.records = append(b.records, rec)
+		return
+	}
+	b.records[b.next] = rec
+	b.next = (b.next + 1) % b.max
+}
+
+// redactDetail rewrites any URLs embedded in an error string through
+// redactURL, so wrapped HTTP errors don't expose query parameters or
+// credentials on /errors. Everything around the URLs passes through as-is.
+func redactDetail(detail string) string {
+	var b strings.Builder
+	rest := detail
+	for {
+		idx := strings.Index(rest, "http://")
+		if j := strings.Index(rest, "https://"); j >= 0 && (idx < 0 || j < idx) {
+			idx = j
+		}
+		if idx < 0 {
+			b.WriteString(rest)
+			return b.String()
+		}
+		b.WriteString(rest[:idx])
+		rest = rest[idx:]
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '"' || r == '\''
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		b.WriteString(redactURL(rest[:end]))
+		rest = rest[end:]
+	}
+}
+
+// snapshot returns the buffered errors oldest-first.
+func (b *errorBuffer) snapshot() []errorRecord {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]errorRecord, 0, len(b.records))
+	out = append(out, b.records[b.next:]...)
+	out = append(out, b.records[:b.next]...)
+	return out
+}
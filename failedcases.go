@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// failedCaseRecord is one JSON line in the failed-cases file: the full
+// source case plus why it failed, machine-readable so the record can be
+// fixed upstream and replayed (see RUN_MODE=replay-failed).
+type failedCaseRecord struct {
+	Time   string     `json:"ts"`
+	Reason string     `json:"reason"`
+	Case   FraudCases `json:"case"`
+}
+
+// failedCaseSink appends failed-case records to FAILED_CASES_PATH. Like the
+// audit sink, writes go through a buffered channel and a single writer
+// goroutine so the sync path never blocks on disk; a full buffer drops and
+// counts rather than stalling.
+type failedCaseSink struct {
+	ch   chan failedCaseRecord
+	done chan struct{}
+}
+
+var failedCases *failedCaseSink
+
+func initFailedCases(cfg *Config) error {
+	if cfg.FailedCasesPath == "" {
+		failedCases = nil
+		return nil
+	}
+	f, err := os.OpenFile(cfg.FailedCasesPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s := &failedCaseSink{
+		ch:   make(chan failedCaseRecord, 1024),
+		done: make(chan struct{}),
+	}
+	go s.run(f)
+	failedCases = s
+	return nil
+}
+
+func (s *failedCaseSink) run(f *os.File) {
+	defer close(s.done)
+	defer f.Close()
+	for record := range s.ch {
+		line, err := json.Marshal(record)
+		if err != nil {
+			continue
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			log.WithError(err).Error("Failed to write failed-case record")
+		}
+	}
+}
+
+// recordFailedCases files every case of a permanently failed batch,
+// non-blocking. Nil-safe so callers need no feature check. Transient
+// failures are deliberately not recorded — the message is requeued and the
+// cases retried whole, so filing them would duplicate records per attempt.
+func recordFailedCases(cases []FraudCases, reason error) {
+	if failedCases == nil || isTransient(reason) {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, fc := range cases {
+		record := failedCaseRecord{Time: now, Reason: reason.Error(), Case: fc}
+		select {
+		case failedCases.ch <- record:
+		default:
+			failedCasesDropped.Inc()
+		}
+	}
+}
+
+// closeFailedCases flushes buffered records and closes the file; call at
+// shutdown.
+func closeFailedCases() {
+	if failedCases == nil {
+		return
+	}
+	close(failedCases.ch)
+	<-failedCases.done
+}
+
+// runFailedCaseReplay reads a failed-cases file and re-attempts the upsert
+// of every recorded case, for remediation after the upstream data was
+// fixed. It runs the normal batched upsert path and returns 1 on failure so
+// the exit code reflects the outcome, mirroring runReplay.
+func runFailedCaseReplay(path string) int {
+	file, err := os.Open(path)
+	failOnError(err, "Failed to open failed-cases file", exitConfig)
+	defer file.Close()
+
+	var cases []FraudCases
+	line := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line++
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		var record failedCaseRecord
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			fmt.Printf("line %d: skipping unparseable record: %v\n", line, err)
+			continue
+		}
+		cases = append(cases, record.Case)
+	}
+	failOnError(scanner.Err(), "Failed to read failed-cases file", exitConfig)
+
+	if len(cases) == 0 {
+		fmt.Println("no failed cases to replay")
+		return 0
+	}
+
+	res := &ProcessResult{}
+	if _, err := upsertPage(context.Background(), cases, res); err != nil {
+		fmt.Printf("replay: FAILED upserted=%d of %d error=%v\n", res.CasesUpserted, len(cases), err)
+		return 1
+	}
+	fmt.Printf("replay: ok upserted=%d\n", res.CasesUpserted)
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFailedCaseSinkRecordsPermanentFailuresOnly(t *testing.T) {
+	cfg = loadConfig()
+	cfg.FailedCasesPath = filepath.Join(t.TempDir(), "failed.jsonl")
+	if err := initFailedCases(cfg); err != nil {
+		t.Fatalf("initFailedCases: %v", err)
+	}
+
+	recordFailedCases([]FraudCases{
+		{CdlId: "case-1", BankCountryCode: "DE"},
+		{CdlId: "case-2"},
+	}, errors.New("Catenax returned status 400"))
+	// Transient failures requeue the whole message; recording them would
+	// file duplicates per delivery attempt.
+	recordFailedCases([]FraudCases{{CdlId: "case-3"}}, transient("network", "connection reset"))
+	closeFailedCases()
+	failedCases = nil
+
+	f, err := os.Open(cfg.FailedCasesPath)
+	if err != nil {
+		t.Fatalf("opening failed-cases file: %v", err)
+	}
+	defer f.Close()
+
+	var records []failedCaseRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record failedCaseRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("unparseable record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("recorded %d cases, want 2 (transient failure must not be filed)", len(records))
+	}
+	if records[0].Case.CdlId != "case-1" || records[0].Reason != "Catenax returned status 400" {
+		t.Errorf("first record = %+v, want case-1 with the upsert reason", records[0])
+	}
+}
+
+func TestFailedCaseReplayReupserts(t *testing.T) {
+	fb := newFakeBackends(t, 1, 0)
+
+	path := filepath.Join(t.TempDir(), "failed.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating failed-cases file: %v", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, id := range []string{"case-1", "case-2", "case-3"} {
+		enc.Encode(failedCaseRecord{Reason: "Catenax returned status 400", Case: FraudCases{CdlId: id}})
+	}
+	f.Close()
+
+	if failures := runFailedCaseReplay(path); failures != 0 {
+		t.Fatalf("runFailedCaseReplay = %d, want 0", failures)
+	}
+	if fb.casesUpserted != 3 {
+		t.Errorf("replay upserted %d cases, want 3", fb.casesUpserted)
+	}
+}
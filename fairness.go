@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// tenantGate caps how many messages may be in flight per tenant at once, so
+// one tenant's burst cannot occupy every worker slot while other tenants
+// wait. Each tenant gets a lazily-created semaphore channel; acquire blocks
+// until a slot frees up or the context is cancelled.
+type tenantGate struct {
+	mu     sync.Mutex
+	perKey int
+	slots  map[string]chan struct{}
+}
+
+// messageGate is nil when MAX_CONCURRENT_PER_KEY is zero (unlimited).
+var messageGate *tenantGate
+
+func initTenantGate(cfg *Config) {
+	if cfg.MaxConcurrentPerKey <= 0 {
+		messageGate = nil
+		return
+	}
+	messageGate = newTenantGate(cfg.MaxConcurrentPerKey)
+}
+
+func newTenantGate(perKey int) *tenantGate {
+	return &tenantGate{perKey: perKey, slots: make(map[string]chan struct{})}
+}
+
+func (g *tenantGate) slot(tenant string) chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sem, ok := g.slots[tenant]
+	if !ok {
+		sem = make(chan struct{}, g.perKey)
+		g.slots[tenant] = sem
+	}
+	return sem
+}
+
+// acquire blocks until the tenant has a free slot. A nil gate admits
+// everything.
+func (g *tenantGate) acquire(ctx context.Context, tenant string) error {
+	if g == nil {
+		return nil
+	}
+	select {
+	case g.slot(tenant) <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *tenantGate) release(tenant string) {
+	if g == nil {
+		return
+	}
+	<-g.slot(tenant)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTenantGateInterleavesTenants(t *testing.T) {
+	g := newTenantGate(1)
+	ctx := context.Background()
+
+	// Tenant a holds its only slot; a second message for a must wait, but
+	// tenant b is admitted immediately — a's burst does not block b.
+	if err := g.acquire(ctx, "a"); err != nil {
+		t.Fatalf("first acquire for a: %v", err)
+	}
+
+	bDone := make(chan struct{})
+	go func() {
+		if err := g.acquire(ctx, "b"); err == nil {
+			close(bDone)
+		}
+	}()
+	select {
+	case <-bDone:
+	case <-time.After(time.Second):
+		t.Fatal("tenant b was blocked by tenant a's slot")
+	}
+
+	aDone := make(chan struct{})
+	go func() {
+		if err := g.acquire(ctx, "a"); err == nil {
+			close(aDone)
+		}
+	}()
+	select {
+	case <-aDone:
+		t.Fatal("second message for tenant a was admitted while the first still held the slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	g.release("a")
+	select {
+	case <-aDone:
+	case <-time.After(time.Second):
+		t.Fatal("second message for tenant a never got the released slot")
+	}
+}
+
+func TestTenantGateAcquireStopsOnCancel(t *testing.T) {
+	g := newTenantGate(1)
+	if err := g.acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.acquire(ctx, "a"); err == nil {
+		t.Fatal("expected acquire to fail after cancellation")
+	}
+}
+
+func TestNilTenantGateAdmitsEverything(t *testing.T) {
+	var g *tenantGate
+	for i := 0; i < 3; i++ {
+		if err := g.acquire(context.Background(), "a"); err != nil {
+			t.Fatalf("nil gate acquire: %v", err)
+		}
+	}
+	g.release("a")
+}
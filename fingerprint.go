@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// fingerprintStore persists a CdlId -> content-hash map per tenant so the
+// next sync can skip upserting cases whose content did not change. Like the
+// explicit-delete idStore it keeps one JSON file per tenant, keyed by the
+// API key hash, in FINGERPRINT_STATE_DIR — which must survive restarts or
+// every restart rewrites everything once. Enabled by setting the directory.
+type fingerprintStore struct {
+	dir string
+}
+
+// caseFingerprints is set up at startup when FINGERPRINT_STATE_DIR is set;
+// nil means every case is upserted unconditionally (the historical behavior).
+var caseFingerprints *fingerprintStore
+
+func initFingerprints(cfg *Config) error {
+	if cfg.FingerprintStateDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.FingerprintStateDir, 0o755); err != nil {
+		return err
+	}
+	caseFingerprints = &fingerprintStore{dir: cfg.FingerprintStateDir}
+	return nil
+}
+
+// caseFingerprint hashes the full case content; any field change produces a
+// new fingerprint and forces a re-upsert.
+func caseFingerprint(fc FraudCases) string {
+	data, _ := json.Marshal(fc)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *fingerprintStore) path(tenant string) string {
+	return filepath.Join(s.dir, tenant+".fingerprints.json")
+}
+
+func (s *fingerprintStore) load(tenant string) (map[string]string, error) {
+	data, err := os.ReadFile(s.path(tenant))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fps := map[string]string{}
+	if err := json.Unmarshal(data, &fps); err != nil {
+		return nil, err
+	}
+	return fps, nil
+}
+
+func (s *fingerprintStore) save(tenant string, fps map[string]string) error {
+	data, err := json.Marshal(fps)
+	if err != nil {
+		return err
+	}
+	// Write-and-rename so a crash mid-save never leaves a torn map.
+	tmp := s.path(tenant) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(tenant))
+}
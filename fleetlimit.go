@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fleetLimiter acquires request tokens from an external rate-limiter service
+// so a CDQ quota holds across every worker replica — a local limiter can
+// only cap one process. The contract is deliberately small (see the README):
+// POST to the configured URL, 2xx grants a token, 429 means wait and ask
+// again, anything else counts as the limiter being unavailable.
+type fleetLimiter struct {
+	url string
+}
+
+// cdqFleetLimiter is nil unless RATE_LIMITER_URL is set.
+var cdqFleetLimiter *fleetLimiter
+
+func initFleetLimiter(cfg *Config) {
+	if cfg.RateLimiterURL != "" {
+		cdqFleetLimiter = &fleetLimiter{url: cfg.RateLimiterURL}
+	}
+}
+
+// acquire blocks until the service grants a token, honoring Retry-After on
+// 429 responses. It reports granted=false — without an error — when the
+// service is unreachable or misbehaving, so the caller can fall back to the
+// local limiter instead of stalling the whole fleet on a limiter outage.
+func (f *fleetLimiter) acquire(ctx context.Context) (granted bool, err error) {
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, f.url, nil)
+		if err != nil {
+			cancel()
+			return false, nil
+		}
+		resp, err := httpClient.Do(req)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			fleetLimiterFallbacks.Inc()
+			log.WithError(err).Warn("Fleet rate limiter unreachable, falling back to local limiter")
+			return false, nil
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return true, nil
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait := time.Second
+			if s := resp.Header.Get("Retry-After"); s != "" {
+				if secs, err := strconv.Atoi(s); err == nil && secs >= 0 {
+					wait = time.Duration(secs) * time.Second
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(wait):
+			}
+		default:
+			fleetLimiterFallbacks.Inc()
+			log.WithField("status", resp.StatusCode).Warn("Fleet rate limiter returned unexpected status, falling back to local limiter")
+			return false, nil
+		}
+	}
+}
+
+// waitCdqTurn enforces the CDQ request rate: the fleet-wide limiter when
+// configured — degrading to the local limiter if the service is down — and
+// the local (or per-message override) limiter otherwise.
+func waitCdqTurn(ctx context.Context) error {
+	if cdqFleetLimiter != nil {
+		granted, err := cdqFleetLimiter.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		if granted {
+			return nil
+		}
+	}
+	if limiter := cdqLimiterFrom(ctx); limiter != nil {
+		return limiter.Wait(ctx)
+	}
+	return nil
+}
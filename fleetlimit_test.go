@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestFleetLimiterWaitsOn429ThenAcquires(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	limiter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("limiter received a %s request, want POST", r.Method)
+		}
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer limiter.Close()
+
+	f := &fleetLimiter{url: limiter.URL}
+	granted, err := f.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if !granted {
+		t.Error("acquire = not granted, want granted after the 429s clear")
+	}
+	mu.Lock()
+	if calls != 3 {
+		t.Errorf("limiter was asked %d times, want 3", calls)
+	}
+	mu.Unlock()
+}
+
+func TestFleetLimiterFallsBackWhenUnavailable(t *testing.T) {
+	limiter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	f := &fleetLimiter{url: limiter.URL}
+
+	granted, err := f.acquire(context.Background())
+	if err != nil || granted {
+		t.Errorf("acquire on 500 = (%v, %v), want not granted without error", granted, err)
+	}
+
+	// A dead endpoint behaves the same: fall back, do not error.
+	limiter.Close()
+	granted, err = f.acquire(context.Background())
+	if err != nil || granted {
+		t.Errorf("acquire on dead endpoint = (%v, %v), want not granted without error", granted, err)
+	}
+}
+
+func TestWaitCdqTurnFallsBackToLocalLimiter(t *testing.T) {
+	cfg = loadConfig()
+	cdqFleetLimiter = &fleetLimiter{url: "http://127.0.0.1:1/limit"}
+	defer func() { cdqFleetLimiter = nil }()
+
+	// The fleet limiter is unreachable; the local limiter must still gate
+	// the request rather than the call erroring out.
+	initCdqLimiter(&Config{CdqRateLimit: 1000})
+	if err := waitCdqTurn(context.Background()); err != nil {
+		t.Errorf("waitCdqTurn with unreachable fleet limiter: %v", err)
+	}
+}
@@ -0,0 +1,926 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"golang.org/x/time/rate"
+)
+
+// ProcessResult summarizes what one processed message actually did, so the
+// metrics layer and tests can assert on concrete outcomes instead of parsing
+// logs. It is populated as far as processing got, even when an error is
+// returned alongside it.
+type ProcessResult struct {
+	PagesFetched   int
+	PagesSkipped   int
+	CasesUpserted  int
+	CasesSkipped   int
+	CasesFiltered  int
+	CasesUnchanged int
+
+	// UpsertedIDs and DeletedIDs are only collected while a lineage
+	// exchange is configured, so the id lists never pile up in memory for
+	// deployments that do not consume them. Cutoff deletes cannot name the
+	// ids they removed; DeletedIDs is filled by explicit delete mode only.
+	UpsertedIDs  []string
+	DeletedIDs   []string
+	Truncated    bool
+	DeleteCutoff time.Time
+	Duration     time.Duration
+}
+
+// extractAPIKey returns the CDQ API key for a delivery, dispatching on the
+// delivery's content type so protobuf publishers can be migrated
+// incrementally alongside plain-text ones. With CDQ_API_KEY_FILE set the
+// message is only a trigger: the key comes from the mounted secret file,
+// re-read per message so rotations apply without a restart.
+func extractAPIKey(msg amqp.Delivery) (string, error) {
+	if cfg.CdqAPIKeyFile != "" {
+		return readAPIKeyFile(cfg.CdqAPIKeyFile)
+	}
+	switch msg.ContentType {
+	case "", "text/plain", "text/plain; charset=utf-8":
+		return extractPlainAPIKey(msg)
+	case "application/x-protobuf", "application/protobuf":
+		req, err := decodeSyncRequest(msg.Body)
+		if err != nil {
+			return "", fmt.Errorf("decoding protobuf message: %w", err)
+		}
+		if len(req.Options) > 0 {
+			log.WithField("options", req.Options).Debug("Ignoring SyncRequest options")
+		}
+		return req.ApiKey, nil
+	default:
+		return "", fmt.Errorf("unsupported message content type %q", msg.ContentType)
+	}
+}
+
+// extractPlainAPIKey handles plain-text deliveries. When RMQ_KEY_HEADER is
+// configured the key is read from that AMQP header, falling back to the
+// body; a key in both places is rejected so publisher mistakes surface
+// instead of being guessed at.
+func extractPlainAPIKey(msg amqp.Delivery) (string, error) {
+	body := strings.TrimSpace(string(msg.Body))
+	if cfg.KeyHeader == "" {
+		if body == "" {
+			return "", fmt.Errorf("message body contains no API key")
+		}
+		return body, nil
+	}
+
+	header := ""
+	if v, ok := msg.Headers[cfg.KeyHeader]; ok {
+		if s, ok := v.(string); ok {
+			header = strings.TrimSpace(s)
+		}
+	}
+	switch {
+	case header != "" && body != "":
+		return "", fmt.Errorf("API key present in both %q header and body, expected exactly one", cfg.KeyHeader)
+	case header != "":
+		return header, nil
+	case body != "":
+		return body, nil
+	default:
+		return "", fmt.Errorf("no API key in %q header or body", cfg.KeyHeader)
+	}
+}
+
+// readAPIKeyFile reads the key from a mounted secret file. The file is
+// validated at startup, so a failure here means it vanished or was rotated
+// to empty underneath us.
+func readAPIKeyFile(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading API key file: %w", err)
+	}
+	key := strings.TrimSpace(string(raw))
+	if key == "" {
+		return "", fmt.Errorf("API key file %q is empty", path)
+	}
+	return key, nil
+}
+
+// applyRegionOverride resolves the optional x-catenax-region header against
+// the CATENAX_REGIONS allowlist and threads the region's base URL through the
+// context. Unknown regions are rejected permanently so a mistyped region
+// never writes to the default one.
+func applyRegionOverride(ctx context.Context, msg amqp.Delivery) (context.Context, error) {
+	v, ok := msg.Headers["x-catenax-region"]
+	if !ok {
+		return ctx, nil
+	}
+	region, ok := v.(string)
+	if !ok || region == "" {
+		return ctx, fmt.Errorf("x-catenax-region header must be a non-empty string")
+	}
+	base, ok := cfg.CatenaxRegions[region]
+	if !ok {
+		return ctx, fmt.Errorf("unknown Catenax region %q, not in CATENAX_REGIONS", region)
+	}
+	addBreadcrumb(ctx, "catenax", "region override applied", map[string]interface{}{
+		"region": region,
+	})
+	return withCatenaxBase(ctx, base), nil
+}
+
+// isKeyBatch reports whether a plain-text delivery carries a JSON array of
+// API keys rather than a single key. Protobuf deliveries have their own
+// envelope and never batch this way.
+func isKeyBatch(msg amqp.Delivery) bool {
+	// With a mounted key file every message is a bare trigger, never a batch.
+	if cfg.CdqAPIKeyFile != "" {
+		return false
+	}
+	switch msg.ContentType {
+	case "", "text/plain", "text/plain; charset=utf-8", "application/json":
+	default:
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(string(msg.Body)), "[")
+}
+
+// handleKeyBatch processes a JSON array of API keys as a sequence of
+// independent syncs, so bulk publishers can enqueue many tenants in one
+// message. Each key gets its own sync state; a failing key never taints the
+// others. Under the all_or_nothing policy the first failure stops the batch
+// and fails the message; under best_effort the remaining keys still run and
+// the message only fails when every key did.
+func handleKeyBatch(ctx context.Context, msg amqp.Delivery) (*ProcessResult, error) {
+	res := &ProcessResult{}
+	batchStart := time.Now()
+	defer func() { res.Duration = time.Since(batchStart) }()
+
+	var keys []string
+	if err := json.Unmarshal(msg.Body, &keys); err != nil {
+		return res, fmt.Errorf("decoding key batch: %w", err)
+	}
+	if len(keys) == 0 {
+		return res, fmt.Errorf("key batch is empty")
+	}
+
+	var failed int
+	var firstErr error
+	for i, key := range keys {
+		sub := msg
+		sub.Body = []byte(key)
+		subRes, err := handleMessage(ctx, sub)
+
+		res.PagesFetched += subRes.PagesFetched
+		res.PagesSkipped += subRes.PagesSkipped
+		res.CasesUpserted += subRes.CasesUpserted
+		res.CasesSkipped += subRes.CasesSkipped
+		res.CasesFiltered += subRes.CasesFiltered
+		res.CasesUnchanged += subRes.CasesUnchanged
+		res.Truncated = res.Truncated || subRes.Truncated
+
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			log.WithError(err).WithFields(log.Fields{
+				"keyIndex": i,
+				"tenant":   tenantHash(key),
+			}).Warn("Key in batch failed")
+			if cfg.BatchKeyPolicy == batchPolicyAllOrNothing {
+				return res, err
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"keys":       len(keys),
+		"keysFailed": failed,
+		"upserted":   res.CasesUpserted,
+		"duration":   time.Since(batchStart).String(),
+	}).Info("Key batch finished")
+	if failed == len(keys) {
+		return res, firstErr
+	}
+	return res, nil
+}
+
+// maxRateOverride caps the x-cdq-rate-limit header; anything above it is a
+// publisher mistake, not a real quota.
+const maxRateOverride = 1000
+
+// applyRateLimitOverride reads the optional x-cdq-rate-limit header (requests
+// per second) and threads a per-message limiter through the context,
+// overriding the global CDQ limiter for this tenant's fetches. Out-of-bounds
+// values are rejected permanently so a broken publisher surfaces instead of
+// hammering CDQ.
+func applyRateLimitOverride(ctx context.Context, msg amqp.Delivery) (context.Context, error) {
+	v, ok := msg.Headers["x-cdq-rate-limit"]
+	if !ok {
+		return ctx, nil
+	}
+	rps, err := headerNumber(v)
+	if err != nil {
+		return ctx, fmt.Errorf("x-cdq-rate-limit header: %w", err)
+	}
+	if rps <= 0 || rps > maxRateOverride {
+		return ctx, fmt.Errorf("x-cdq-rate-limit %v out of bounds (0, %d]", rps, maxRateOverride)
+	}
+	addBreadcrumb(ctx, "cdq", "rate-limit override applied", map[string]interface{}{
+		"rps": rps,
+	})
+	return withCdqLimiter(ctx, rate.NewLimiter(rate.Limit(rps), 1)), nil
+}
+
+// headerNumber coerces the numeric AMQP header types publishers actually
+// send (including stringified numbers) into a float64.
+func headerNumber(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a number: %q", n)
+		}
+		return parsed, nil
+	default:
+		return 0, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// maxTimeoutOverride caps the per-message timeout headers; tenants may buy
+// themselves time, but not park a worker slot indefinitely.
+const maxTimeoutOverride = 5 * time.Minute
+
+// timeoutOverrideHeaders maps each envelope header to the stage it controls.
+var timeoutOverrideHeaders = map[string]string{
+	"x-timeout-fetch-ms":  stageFetch,
+	"x-timeout-upsert-ms": stageUpsert,
+	"x-timeout-delete-ms": stageDelete,
+}
+
+// applyTimeoutOverrides reads the optional per-stage timeout headers
+// (milliseconds) and threads them through the context, overriding
+// REQUEST_TIMEOUT_MS for this message only. Values above the cap are clamped
+// rather than rejected — a slow tenant asking for too much still gets the
+// maximum we allow; non-positive or unparseable values are publisher bugs
+// and fail the message permanently.
+func applyTimeoutOverrides(ctx context.Context, msg amqp.Delivery) (context.Context, error) {
+	var overrides map[string]time.Duration
+	for header, stage := range timeoutOverrideHeaders {
+		v, ok := msg.Headers[header]
+		if !ok {
+			continue
+		}
+		ms, err := headerNumber(v)
+		if err != nil {
+			return ctx, fmt.Errorf("%s header: %w", header, err)
+		}
+		if ms <= 0 {
+			return ctx, fmt.Errorf("%s must be positive, got %v", header, ms)
+		}
+		d := time.Duration(ms) * time.Millisecond
+		if d > maxTimeoutOverride {
+			d = maxTimeoutOverride
+		}
+		if overrides == nil {
+			overrides = make(map[string]time.Duration, len(timeoutOverrideHeaders))
+		}
+		overrides[stage] = d
+	}
+	if overrides == nil {
+		return ctx, nil
+	}
+	addBreadcrumb(ctx, "config", "timeout overrides applied", map[string]interface{}{
+		"overrides": fmt.Sprintf("%v", overrides),
+	})
+	return withTimeoutOverrides(ctx, overrides), nil
+}
+
+// handleMessage runs one full sync for the CDQ API key carried in the
+// message body: fetch all pages from CDQ, upsert them to Catenax in batches,
+// then delete everything on the Catenax side the sync did not touch.
+func handleMessage(ctx context.Context, msg amqp.Delivery) (*ProcessResult, error) {
+	if isKeyBatch(msg) {
+		return handleKeyBatch(ctx, msg)
+	}
+
+	res := &ProcessResult{}
+	syncStart := time.Now()
+	defer func() { res.Duration = time.Since(syncStart) }()
+
+	// The budget caps the whole message: every stage inherits this deadline,
+	// so per-request timeouts are implicitly clamped to whatever budget the
+	// earlier stages left over — a slow fetch leaves less for the upserts,
+	// and the message as a whole never exceeds MESSAGE_BUDGET_MS.
+	if cfg.MessageBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MessageBudget)
+		defer cancel()
+	}
+
+	// Protobuf payloads have their own structural validation in the decoder;
+	// the JSON Schema contract only applies to plain/JSON envelopes.
+	if msg.ContentType != "application/x-protobuf" && msg.ContentType != "application/protobuf" {
+		if err := validateMessageBody(msg.Body); err != nil {
+			return res, err
+		}
+	}
+
+	apiKey, err := extractAPIKey(msg)
+	if err != nil {
+		return res, err
+	}
+
+	ctx, err = applyRegionOverride(ctx, msg)
+	if err != nil {
+		return res, err
+	}
+
+	ctx, err = applyRateLimitOverride(ctx, msg)
+	if err != nil {
+		return res, err
+	}
+
+	ctx, err = applyTimeoutOverrides(ctx, msg)
+	if err != nil {
+		return res, err
+	}
+
+	// cutoff tracks the oldest updatedAt Catenax reported for our upserts.
+	// Deleting everything older removes cases that vanished upstream without
+	// touching anything this sync wrote. The cutoff is compared and sent
+	// purely on the server's clock — mixing in the worker's clock would
+	// misbehave under skew — so it starts zero and only ever takes
+	// server-provided values.
+	var cutoff time.Time
+
+	ctx = withPageSizer(ctx, newPageSizer(cfg))
+	ctx = withCallBudget(ctx, newCallBudget(cfg))
+
+	// One batch id per message tags every upsert of this run, so the Catenax
+	// side can group and audit records by sync run.
+	batchID := randomHex16()
+	ctx = withSyncBatchID(ctx, batchID)
+
+	totalFetched := 0
+	var currentIDs []string
+	var diffCases []FraudCases
+
+	// With fingerprinting on, the previous sync's content hashes decide which
+	// cases actually need rewriting this time.
+	var priorFPs, nextFPs map[string]string
+	if caseFingerprints != nil {
+		priorFPs, err = caseFingerprints.load(tenantHash(apiKey))
+		if err != nil {
+			return res, fmt.Errorf("loading case fingerprints: %w", err)
+		}
+		nextFPs = make(map[string]string, len(priorFPs))
+	}
+
+	// processCases runs the shared per-batch pipeline for both pagination
+	// modes: case cap, id collection for explicit delete, upsert and cutoff
+	// aggregation.
+	processCases := func(cases []FraudCases) error {
+		totalFetched += len(cases)
+
+		// Soft-deleted cases are dropped before the id collection and the
+		// upsert, so neither delete mode sees them as current: explicit mode
+		// treats them as vanished, cutoff mode lets their Catenax records age
+		// past the cutoff. Either way the stale record is cleaned up.
+		if cfg.CdqSkipInactive {
+			cases = dropInactiveCases(cases, res)
+		}
+
+		// Safety valve for new tenants: stop writing once the configured cap
+		// is reached instead of syncing an unexpectedly large dataset.
+		if max := cfg.MaxCasesPerMessage; max > 0 {
+			if keep := max - res.CasesUpserted - res.CasesSkipped; len(cases) > keep {
+				cases = cases[:keep]
+				res.Truncated = true
+			}
+		}
+
+		if cfg.DeleteMode == deleteModeExplicit {
+			for _, fc := range cases {
+				currentIDs = append(currentIDs, fc.CdlId)
+			}
+		}
+
+		// The unchanged-case filter runs after the explicit-delete id
+		// collection on purpose: a skipped case is still present upstream
+		// and must not look vanished.
+		if caseFingerprints != nil {
+			cases = dropUnchangedCases(cases, priorFPs, nextFPs, res)
+		}
+
+		// Diff mode is read-only: keep the cases for the comparison at the
+		// end instead of writing them.
+		if cfg.DiffMode {
+			diffCases = append(diffCases, cases...)
+			res.CasesSkipped += len(cases)
+			return nil
+		}
+
+		batchCutoff, err := upsertPage(ctx, cases, res)
+		if err != nil {
+			return err
+		}
+		if lineagePub != nil {
+			for _, fc := range cases {
+				res.UpsertedIDs = append(res.UpsertedIDs, fc.CdlId)
+			}
+		}
+		if !batchCutoff.IsZero() && (cutoff.IsZero() || batchCutoff.Before(cutoff)) {
+			cutoff = batchCutoff
+		}
+		if res.Truncated {
+			log.WithField("maxCasesPerMessage", cfg.MaxCasesPerMessage).Warn("Case cap reached, stopping early")
+		}
+		return nil
+	}
+
+	if cfg.CdqResponseMode == cdqResponseModeStream {
+		// Stream mode: one response, no paging metadata; batches are upserted
+		// as the stream is read.
+		res.PagesFetched++
+		err := fetchFraudCasesStream(ctx, apiKey, func(cases []FraudCases) error {
+			addBreadcrumb(ctx, "cdq", "stream batch decoded", map[string]interface{}{
+				"cases": len(cases),
+			})
+			if err := processCases(cases); err != nil {
+				return err
+			}
+			if res.Truncated {
+				return errStopStreaming
+			}
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+	} else if cfg.CdqPagination == cdqPaginationCursor {
+		cursor := ""
+		for {
+			response, err := fetchFraudCasesCursor(ctx, apiKey, cursor)
+			if err != nil {
+				return res, err
+			}
+			res.PagesFetched++
+			addBreadcrumb(ctx, "cdq", "cursor page fetched", map[string]interface{}{
+				"cases": len(response.FraudCases),
+			})
+			if err := processCases(response.FraudCases); err != nil {
+				return res, err
+			}
+			if res.Truncated || response.NextCursor == "" {
+				break
+			}
+			cursor = response.NextCursor
+		}
+	} else {
+		firstPage, err := fetchFraudCases(ctx, apiKey, 1)
+		if err != nil {
+			return res, err
+		}
+		res.PagesFetched++
+
+		numberOfPages := firstPage.NumberOfPages
+		log.WithField("numberOfPages", numberOfPages).Debug("Fetched first page")
+		warnOnPageAnomalies(tenantHash(apiKey), numberOfPages)
+
+		if cfg.CdqFetchConcurrency > 1 && numberOfPages > 2 {
+			// Concurrent fetch completes pages in arbitrary order; the
+			// results come back indexed by page so upserts still happen in
+			// page order and downstream sees a deterministic sequence.
+			pages, err := fetchRemainingPages(ctx, apiKey, numberOfPages, res)
+			if err != nil {
+				return res, err
+			}
+			if err := processCases(checkEmptyMiddlePage(ctx, apiKey, 1, numberOfPages, firstPage.FraudCases)); err != nil {
+				return res, err
+			}
+			for page := 2; page <= numberOfPages && !res.Truncated; page++ {
+				cases, ok := pages[page]
+				if !ok {
+					// Skipped in best-effort mode; already counted there.
+					continue
+				}
+				cases = checkEmptyMiddlePage(ctx, apiKey, page, numberOfPages, cases)
+				addBreadcrumb(ctx, "cdq", fmt.Sprintf("page %d fetched", page), map[string]interface{}{
+					"cases": len(cases),
+				})
+				if err := processCases(cases); err != nil {
+					return res, err
+				}
+			}
+		} else {
+			for page := 1; page <= numberOfPages; page++ {
+				var cases []FraudCases
+				if page == 1 {
+					cases = firstPage.FraudCases
+				} else {
+					response, err := fetchFraudCases(ctx, apiKey, page)
+					if err != nil {
+						// Best-effort mode trades completeness for progress: one
+						// persistently-failing page no longer sinks the whole
+						// tenant. The delete is skipped further down because the
+						// dataset is incomplete. Cancellation still aborts.
+						if cfg.CdqBestEffortPages && !errors.Is(err, context.Canceled) {
+							res.PagesSkipped++
+							cdqPagesSkipped.Inc()
+							log.WithError(err).WithField("page", page).Warn("Skipping failed page in best-effort mode")
+							continue
+						}
+						return res, err
+					}
+					res.PagesFetched++
+					cases = response.FraudCases
+				}
+				cases = checkEmptyMiddlePage(ctx, apiKey, page, numberOfPages, cases)
+				addBreadcrumb(ctx, "cdq", fmt.Sprintf("page %d fetched", page), map[string]interface{}{
+					"cases": len(cases),
+				})
+				if err := processCases(cases); err != nil {
+					return res, err
+				}
+				if res.Truncated {
+					break
+				}
+			}
+		}
+	}
+	// Last-resort fallback: with no upsert response to take a server
+	// timestamp from, the local sync start is the only anchor left. It is
+	// only ever deleted on for an empty sync with DELETE_ON_EMPTY set; when
+	// cases were written without a server timestamp the delete is skipped
+	// below, keeping the cutoff comparison on the server's clock.
+	serverCutoff := !cutoff.IsZero()
+	if !serverCutoff {
+		cutoff = syncStart
+	}
+	res.DeleteCutoff = cutoff
+
+	switch {
+	case res.Truncated:
+		// Deleting against a truncated dataset would remove cases that were
+		// simply never fetched, so the delete is skipped entirely.
+		log.Warn("Skipping delete because the case cap truncated the dataset")
+	case res.PagesSkipped > 0:
+		// Same reasoning as truncation: a skipped page means the fetched set
+		// is incomplete and the cutoff would delete its cases.
+		log.WithField("pagesSkipped", res.PagesSkipped).Warn("Skipping delete because pages were skipped in best-effort mode")
+	case res.CasesUnchanged > 0 && cfg.DeleteMode == deleteModeCutoff:
+		// Fingerprint-skipped cases were not rewritten, so their Catenax
+		// updatedAt predates this sync's cutoff and the delete would remove
+		// them. Explicit delete mode pairs safely with fingerprinting.
+		log.WithField("unchanged", res.CasesUnchanged).Info("Skipping cutoff delete because unchanged cases were not rewritten")
+	case !serverCutoff && res.CasesUpserted > 0 && cfg.DeleteMode == deleteModeCutoff:
+		// Cases were written but every upsert response was a bare 2xx with
+		// no updatedAt, so the only cutoff on offer is the worker's clock —
+		// and under server-behind skew that would delete what this sync
+		// just wrote.
+		log.WithField("casesUpserted", res.CasesUpserted).Warn("Skipping delete because no upsert returned a server timestamp for the cutoff")
+	case cutoff.After(time.Now().Add(cfg.CutoffFutureMargin)):
+		// A cutoff in the future means the server clock is broken; deleting
+		// "everything older" would be deleting everything. Skip the delete
+		// and alert loudly — this needs a human, not a retry.
+		futureCutoffsRejected.Inc()
+		err := fmt.Errorf("delete cutoff %s is %s in the future, refusing to delete",
+			cutoff.UTC().Format(time.RFC3339), time.Until(cutoff).Round(time.Second))
+		captureException(ctx, err)
+		log.WithField("cutoff", cutoff.UTC().Format(time.RFC3339)).Error("Skipping delete: cutoff implausibly far in the future")
+	case totalFetched == 0 && !cfg.DeleteOnEmpty:
+		// An empty fetch is more likely an upstream hiccup than a tenant
+		// genuinely dropping to zero cases; deleting with a near-now cutoff
+		// would wipe the tenant's Catenax data.
+		log.Warn("CDQ returned zero fraud cases, skipping delete (set DELETE_ON_EMPTY to override)")
+	case cfg.DiffMode:
+		if err := reportDiff(ctx, diffCases); err != nil {
+			return res, err
+		}
+	case dryRun():
+		log.WithField("cutoff", cutoff.UTC().Format(time.RFC3339)).Info("Dry run, skipping delete")
+	case cfg.DeleteMode == deleteModeExplicit:
+		if err := explicitDelete(ctx, apiKey, currentIDs, res); err != nil {
+			return res, err
+		}
+	default:
+		// Both time formats on purpose: RFC3339 matches what the delete
+		// request sends, epoch millis matches the source data's DateOfAttack
+		// values, so either side can be cross-referenced directly.
+		log.WithFields(log.Fields{
+			"cutoff":        cutoff.UTC().Format(time.RFC3339),
+			"cutoffEpochMs": cutoff.UnixMilli(),
+			"casesUpserted": res.CasesUpserted,
+		}).Info("Deleting cases older than cutoff")
+		addBreadcrumb(ctx, "catenax", "delete issued", map[string]interface{}{
+			"cutoff": cutoff.UTC().Format(time.RFC3339),
+		})
+		if err := deleteFraudCases(ctx, cutoff); err != nil {
+			return res, err
+		}
+	}
+
+	if caseFingerprints != nil && !dryRun() && !cfg.DiffMode {
+		if err := caseFingerprints.save(tenantHash(apiKey), nextFPs); err != nil {
+			return res, fmt.Errorf("saving case fingerprints: %w", err)
+		}
+		if totalFetched > 0 {
+			log.WithFields(log.Fields{
+				"unchanged": res.CasesUnchanged,
+				"fetched":   totalFetched,
+			}).Info("Fingerprint skip ratio")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"pages":         res.PagesFetched,
+		"cases":         res.CasesUpserted,
+		"syncBatchId":   batchID,
+		"cutoff":        cutoff.UTC().Format(time.RFC3339),
+		"cutoffEpochMs": cutoff.UnixMilli(),
+		"duration":      time.Since(syncStart).String(),
+	}).Info("Sync finished")
+	publishLineage(batchID, tenantHash(apiKey), res)
+	return res, nil
+}
+
+// checkEmptyMiddlePage flags a non-final page that came back with zero
+// cases. Server-side filtering legitimately makes pages shorter than
+// pageSize, but a completely empty page before the last one usually means
+// CDQ silently dropped data. With CDQ_RETRY_EMPTY_PAGES the page is fetched
+// once more and the refetched result is used; without it the empty page is
+// only logged and counted so the anomaly is at least visible.
+func checkEmptyMiddlePage(ctx context.Context, apiKey string, page, numberOfPages int, cases []FraudCases) []FraudCases {
+	if len(cases) > 0 || page >= numberOfPages {
+		return cases
+	}
+	cdqPageWarnings.WithLabelValues("empty_page").Inc()
+	log.WithFields(log.Fields{
+		"page":          page,
+		"numberOfPages": numberOfPages,
+	}).Warn("Non-final CDQ page returned zero cases")
+	if !cfg.CdqRetryEmptyPages {
+		return cases
+	}
+	response, err := fetchFraudCases(ctx, apiKey, page)
+	if err != nil {
+		log.WithError(err).WithField("page", page).Warn("Refetch of empty page failed, keeping the empty result")
+		return cases
+	}
+	if len(response.FraudCases) > 0 {
+		log.WithFields(log.Fields{
+			"page":  page,
+			"cases": len(response.FraudCases),
+		}).Info("Refetch of empty page recovered cases")
+	}
+	return response.FraudCases
+}
+
+// dropInactiveCases filters out the cases CDQ flagged as soft-deleted,
+// counting what it removed.
+func dropInactiveCases(cases []FraudCases, res *ProcessResult) []FraudCases {
+	active := make([]FraudCases, 0, len(cases))
+	for _, fc := range cases {
+		if isInactiveCase(fc) {
+			continue
+		}
+		active = append(active, fc)
+	}
+	if n := len(cases) - len(active); n > 0 {
+		res.CasesFiltered += n
+		inactiveCasesFiltered.Add(float64(n))
+		log.WithField("cases", n).Debug("Filtered soft-deleted fraud cases")
+	}
+	return active
+}
+
+// dropUnchangedCases filters out cases whose fingerprint matches the
+// previous sync, recording every current fingerprint in next for the store.
+func dropUnchangedCases(cases []FraudCases, prior, next map[string]string, res *ProcessResult) []FraudCases {
+	changed := make([]FraudCases, 0, len(cases))
+	for _, fc := range cases {
+		fp := caseFingerprint(fc)
+		next[fc.CdlId] = fp
+		if prior[fc.CdlId] == fp {
+			continue
+		}
+		changed = append(changed, fc)
+	}
+	if n := len(cases) - len(changed); n > 0 {
+		res.CasesUnchanged += n
+		unchangedCasesSkipped.Add(float64(n))
+		log.WithField("cases", n).Debug("Skipping unchanged fraud cases")
+	}
+	return changed
+}
+
+// fetchRemainingPages fetches pages 2..numberOfPages with CDQ_FETCH_CONCURRENCY
+// workers, returning results keyed by page number so the caller reassembles
+// them in page order no matter how fetches interleave. Best-effort mode
+// records skipped pages the same way the sequential path does; otherwise the
+// first failure cancels the remaining fetches.
+func fetchRemainingPages(ctx context.Context, apiKey string, numberOfPages int, res *ProcessResult) (map[int][]FraudCases, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, cfg.CdqFetchConcurrency)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		pages    = make(map[int][]FraudCases, numberOfPages-1)
+		firstErr error
+	)
+
+	for page := 2; page <= numberOfPages; page++ {
+		page := page
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			response, err := fetchFraudCases(ctx, apiKey, page)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if cfg.CdqBestEffortPages && !errors.Is(err, context.Canceled) {
+					res.PagesSkipped++
+					cdqPagesSkipped.Inc()
+					log.WithError(err).WithField("page", page).Warn("Skipping failed page in best-effort mode")
+					return
+				}
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			res.PagesFetched++
+			pages[page] = response.FraudCases
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return pages, nil
+}
+
+// explicitDelete removes exactly the cases that existed in the previous sync
+// but are absent now, then records the current id set for the next run. The
+// prior set comes from the persisted idStore, so the very first sync of a
+// tenant deletes nothing.
+func explicitDelete(ctx context.Context, apiKey string, currentIDs []string, res *ProcessResult) error {
+	tenant := tenantHash(apiKey)
+	prior, err := deleteIDStore.load(tenant)
+	if err != nil {
+		return fmt.Errorf("loading prior id set: %w", err)
+	}
+
+	current := make(map[string]bool, len(currentIDs))
+	for _, id := range currentIDs {
+		current[id] = true
+	}
+	var vanished []string
+	for id := range prior {
+		if !current[id] {
+			vanished = append(vanished, id)
+		}
+	}
+
+	if len(vanished) > 0 {
+		addBreadcrumb(ctx, "catenax", "explicit delete issued", map[string]interface{}{
+			"cases": len(vanished),
+		})
+		if err := deleteFraudCasesByIDs(ctx, vanished); err != nil {
+			return err
+		}
+		if lineagePub != nil {
+			res.DeletedIDs = append(res.DeletedIDs, vanished...)
+		}
+		log.WithField("cases", len(vanished)).Info("Deleted vanished fraud cases")
+	}
+	return deleteIDStore.save(tenant, currentIDs)
+}
+
+// upsertPage writes one page of cases to Catenax in configured batch sizes
+// and returns the oldest updatedAt across the page's batches. Batches run
+// concurrently when CATENAX_BATCH_CONCURRENCY is above one; the first batch
+// failure cancels the remaining ones.
+func upsertPage(ctx context.Context, cases []FraudCases, res *ProcessResult) (time.Time, error) {
+	var batches [][]FraudCases
+	for start := 0; start < len(cases); start += cfg.CatenaxBatchSize {
+		end := start + cfg.CatenaxBatchSize
+		if end > len(cases) {
+			end = len(cases)
+		}
+		batches = append(batches, cases[start:end])
+	}
+
+	if dryRun() {
+		for _, batch := range batches {
+			log.WithField("batchSize", len(batch)).Info("Dry run, skipping upsert")
+			res.CasesSkipped += len(batch)
+		}
+		return time.Time{}, nil
+	}
+
+	// Under memory pressure batches run serially too — concurrent batches
+	// are the biggest in-flight allocation this worker makes.
+	if cfg.CatenaxBatchConcurrency <= 1 || memGuard.isThrottled() {
+		var oldest time.Time
+		for _, batch := range batches {
+			updatedAt, err := upsertFraudCases(ctx, batch)
+			if err != nil {
+				recordFailedCases(batch, err)
+				return time.Time{}, err
+			}
+			res.CasesUpserted += len(batch)
+			addBreadcrumb(ctx, "catenax", "batch upserted", map[string]interface{}{
+				"batchSize": len(batch),
+			})
+			// A zero updatedAt (non-JSON success body) carries no server
+			// timestamp and must not drag the cutoff to zero.
+			if !updatedAt.IsZero() && (oldest.IsZero() || updatedAt.Before(oldest)) {
+				oldest = updatedAt
+			}
+		}
+		return oldest, nil
+	}
+	return upsertBatchesConcurrently(ctx, batches, res)
+}
+
+// upsertBatchesConcurrently runs the page's batches with bounded
+// concurrency, aggregating the minimum updatedAt under a mutex so the delete
+// cutoff stays correct regardless of completion order.
+func upsertBatchesConcurrently(ctx context.Context, batches [][]FraudCases, res *ProcessResult) (time.Time, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, cfg.CatenaxBatchConcurrency)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		oldest   time.Time
+		upserted int
+		firstErr error
+	)
+
+	for _, batch := range batches {
+		batch := batch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			updatedAt, err := upsertFraudCases(ctx, batch)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				recordFailedCases(batch, err)
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			upserted += len(batch)
+			if !updatedAt.IsZero() && (oldest.IsZero() || updatedAt.Before(oldest)) {
+				oldest = updatedAt
+			}
+		}()
+	}
+	wg.Wait()
+
+	res.CasesUpserted += upserted
+	if firstErr != nil {
+		return time.Time{}, firstErr
+	}
+	addBreadcrumb(ctx, "catenax", "page upserted concurrently", map[string]interface{}{
+		"batches": len(batches),
+	})
+	return oldest, nil
+}
@@ -0,0 +1,915 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fakeBackends stands in for the CDQ and Catenax APIs and records what the
+// worker did to them. newFakeBackends wires them into the global config.
+type fakeBackends struct {
+	mu            sync.Mutex
+	casesUpserted int
+	deleteCalls   int
+
+	cdq     *httptest.Server
+	catenax *httptest.Server
+}
+
+func newFakeBackends(t *testing.T, numberOfPages, casesPerPage int) *fakeBackends {
+	t.Helper()
+	fb := &fakeBackends{}
+
+	fb.cdq = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		cases := make([]FraudCases, casesPerPage)
+		for i := range cases {
+			cases[i] = FraudCases{CdlId: fmt.Sprintf("case-%s-%d", page, i), BankCountryCode: "DE"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NumberOfPages: numberOfPages, FraudCases: cases})
+	}))
+	t.Cleanup(fb.cdq.Close)
+
+	fb.catenax = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			var payload UpsertFraudCasesRequest
+			json.NewDecoder(r.Body).Decode(&payload)
+			fb.mu.Lock()
+			fb.casesUpserted += len(payload.FraudCases)
+			fb.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: time.Now().UnixMilli()})
+		case http.MethodDelete:
+			fb.mu.Lock()
+			fb.deleteCalls++
+			fb.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(fb.catenax.Close)
+
+	cfg = loadConfig()
+	cfg.CdqAPIURL = fb.cdq.URL
+	cfg.CatenaxAPIURL = fb.catenax.URL
+	cfg.CdqMaxRetries = 0
+	cfg.CdqRetryBase = time.Millisecond
+	cfg.CatenaxMaxRetries = 0
+	cfg.CatenaxRetryBase = time.Millisecond
+	initCdqLimiter(cfg)
+	initBreakers(cfg)
+	return fb
+}
+
+func TestConcurrentBatchUpsertAggregatesOldestCutoff(t *testing.T) {
+	// Each upsert response gets a distinct updatedAt; regardless of
+	// completion order the aggregated cutoff must be the true minimum.
+	base := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	served := 0
+	catenax := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		updatedAt := base.Add(time.Duration(served) * time.Minute)
+		served++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: updatedAt.UnixMilli()})
+	}))
+	defer catenax.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = catenax.URL
+	cfg.CatenaxBatchSize = 1
+	cfg.CatenaxBatchConcurrency = 4
+	initBreakers(cfg)
+
+	cases := make([]FraudCases, 10)
+	res := &ProcessResult{}
+	oldest, err := upsertPage(context.Background(), cases, res)
+	if err != nil {
+		t.Fatalf("upsertPage: %v", err)
+	}
+	if !oldest.Equal(base) {
+		t.Errorf("aggregated cutoff = %v, want %v", oldest, base)
+	}
+	if res.CasesUpserted != 10 {
+		t.Errorf("CasesUpserted = %d, want 10", res.CasesUpserted)
+	}
+}
+
+func TestConcurrentBatchUpsertFailureCancelsAndErrors(t *testing.T) {
+	catenax := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer catenax.Close()
+
+	cfg = loadConfig()
+	cfg.CatenaxAPIURL = catenax.URL
+	cfg.CatenaxBatchSize = 1
+	cfg.CatenaxBatchConcurrency = 2
+	initBreakers(cfg)
+
+	res := &ProcessResult{}
+	if _, err := upsertPage(context.Background(), make([]FraudCases, 6), res); err == nil {
+		t.Fatal("expected an error from a failing batch")
+	}
+}
+
+func TestMaxCasesPerMessageTruncatesAndSkipsDelete(t *testing.T) {
+	fb := newFakeBackends(t, 3, 10)
+	cfg.MaxCasesPerMessage = 15
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if !res.Truncated {
+		t.Error("expected result to be marked truncated")
+	}
+	if res.CasesUpserted != 15 {
+		t.Errorf("CasesUpserted = %d, want 15", res.CasesUpserted)
+	}
+	if fb.casesUpserted != 15 {
+		t.Errorf("backend received %d cases, want 15", fb.casesUpserted)
+	}
+	if fb.deleteCalls != 0 {
+		t.Errorf("delete was called %d times, want 0 after truncation", fb.deleteCalls)
+	}
+}
+
+func TestSyncBatchIDStableAcrossBatches(t *testing.T) {
+	var mu sync.Mutex
+	ids := map[string]bool{}
+	catenax := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			ids[r.Header.Get("X-Sync-Batch-Id")] = true
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: time.Now().UnixMilli()})
+	}))
+	defer catenax.Close()
+
+	newFakeBackends(t, 1, 6)
+	cfg.CatenaxAPIURL = catenax.URL
+	cfg.CatenaxBatchSize = 2
+	cfg.SyncBatchIDMode = batchIDModeHeader
+
+	if _, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")}); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("saw %d distinct batch ids across batches, want 1: %v", len(ids), ids)
+	}
+	for id := range ids {
+		if id == "" {
+			t.Error("batch id header was empty")
+		}
+	}
+}
+
+func TestAPICallBudgetAbortsRunawayMessage(t *testing.T) {
+	newFakeBackends(t, 5, 10)
+	// Five pages mean five fetches plus five upserts plus a delete; a budget
+	// of three has to abort mid-sync.
+	cfg.MaxAPICallsPerMessage = 3
+
+	_, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err == nil {
+		t.Fatal("expected the call budget to abort the message")
+	}
+	if isTransient(err) {
+		t.Error("budget exhaustion should be a permanent error")
+	}
+}
+
+func TestRegionOverrideRoutesToAllowlistedRegion(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+
+	regionUpserts := 0
+	region := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			regionUpserts++
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: time.Now().UnixMilli()})
+	}))
+	defer region.Close()
+	cfg.CatenaxRegions = map[string]string{"eu": region.URL}
+
+	msg := amqp.Delivery{
+		Body:    []byte("test-key"),
+		Headers: amqp.Table{"x-catenax-region": "eu"},
+	}
+	if _, err := handleMessage(context.Background(), msg); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if regionUpserts != 1 {
+		t.Errorf("region endpoint received %d upserts, want 1", regionUpserts)
+	}
+	if fb.casesUpserted != 0 {
+		t.Errorf("default endpoint received %d cases, want 0", fb.casesUpserted)
+	}
+}
+
+func TestRegionOverrideRejectsUnknownRegion(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+	cfg.CatenaxRegions = map[string]string{"eu": fb.catenax.URL}
+
+	msg := amqp.Delivery{
+		Body:    []byte("test-key"),
+		Headers: amqp.Table{"x-catenax-region": "mars"},
+	}
+	_, err := handleMessage(context.Background(), msg)
+	if err == nil {
+		t.Fatal("expected an error for an unknown region")
+	}
+	if isTransient(err) {
+		t.Error("unknown region should be a permanent error")
+	}
+	if fb.casesUpserted != 0 {
+		t.Errorf("default endpoint received %d cases, want 0", fb.casesUpserted)
+	}
+}
+
+func TestCursorPaginationFollowsNextCursor(t *testing.T) {
+	// Three cursor pages chained via nextCursor; the last page returns an
+	// empty cursor and ends the loop.
+	cursors := map[string]string{"": "c1", "c1": "c2", "c2": ""}
+	var mu sync.Mutex
+	fetched := 0
+	cdq := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		next, ok := cursors[cursor]
+		if !ok {
+			t.Errorf("unexpected cursor %q", cursor)
+		}
+		mu.Lock()
+		fetched++
+		mu.Unlock()
+		cases := make([]FraudCases, 5)
+		for i := range cases {
+			cases[i] = FraudCases{CdlId: fmt.Sprintf("case-%s-%d", cursor, i), BankCountryCode: "DE"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NextCursor: next, FraudCases: cases})
+	}))
+	defer cdq.Close()
+
+	fb := newFakeBackends(t, 1, 0)
+	cfg.CdqAPIURL = cdq.URL
+	cfg.CdqPagination = cdqPaginationCursor
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if fetched != 3 {
+		t.Errorf("CDQ served %d cursor pages, want 3", fetched)
+	}
+	if res.PagesFetched != 3 {
+		t.Errorf("PagesFetched = %d, want 3", res.PagesFetched)
+	}
+	if fb.casesUpserted != 15 {
+		t.Errorf("backend received %d cases, want 15", fb.casesUpserted)
+	}
+}
+
+func TestMaxCasesPerMessageUnsetSyncsEverything(t *testing.T) {
+	fb := newFakeBackends(t, 2, 10)
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if res.Truncated {
+		t.Error("result unexpectedly marked truncated")
+	}
+	if fb.casesUpserted != 20 {
+		t.Errorf("backend received %d cases, want 20", fb.casesUpserted)
+	}
+	if fb.deleteCalls != 1 {
+		t.Errorf("delete was called %d times, want 1", fb.deleteCalls)
+	}
+}
+
+func TestDeleteCutoffUsesServerClockOnly(t *testing.T) {
+	// The Catenax server clock runs an hour ahead of the worker. The cutoff
+	// must be the server-provided updatedAt, never clamped to the worker's
+	// local sync start — mixing the two clocks under skew would delete
+	// cases this sync just wrote.
+	serverNow := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	fb := newFakeBackends(t, 1, 5)
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: serverNow.UnixMilli()})
+		case http.MethodDelete:
+			fb.mu.Lock()
+			fb.deleteCalls++
+			fb.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if !res.DeleteCutoff.Equal(serverNow) {
+		t.Errorf("DeleteCutoff = %v, want server updatedAt %v", res.DeleteCutoff, serverNow)
+	}
+}
+
+func TestDeleteSkippedWhenUpsertsCarryNoServerTimestamp(t *testing.T) {
+	// Plain-text 2xx upsert responses are accepted but carry no updatedAt,
+	// so there is no server timestamp to build a cutoff from. The delete
+	// must be skipped rather than run on the worker's clock — under
+	// server-behind skew that cutoff would cover cases this sync just wrote.
+	fb := newFakeBackends(t, 1, 5)
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "OK")
+		case http.MethodDelete:
+			fb.mu.Lock()
+			fb.deleteCalls++
+			fb.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if res.CasesUpserted != 5 {
+		t.Fatalf("CasesUpserted = %d, want 5", res.CasesUpserted)
+	}
+	if fb.deleteCalls != 0 {
+		t.Errorf("delete was called %d times, want 0 without a server cutoff", fb.deleteCalls)
+	}
+}
+
+func TestKeyBatchProcessesEveryKey(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte(`["key-a", "key-b"]`)})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if fb.casesUpserted != 10 {
+		t.Errorf("backend received %d cases, want 10 (5 per key)", fb.casesUpserted)
+	}
+	if res.CasesUpserted != 10 {
+		t.Errorf("CasesUpserted = %d, want 10", res.CasesUpserted)
+	}
+	if fb.deleteCalls != 2 {
+		t.Errorf("delete was called %d times, want one per key", fb.deleteCalls)
+	}
+}
+
+func TestKeyBatchBestEffortSurvivesOneFailingKey(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+	cfg.BatchKeyPolicy = batchPolicyBestEffort
+	cdqHandler := fb.cdq.Config.Handler
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-KEY") == "bad-key" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cdqHandler.ServeHTTP(w, r)
+	})
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte(`["bad-key", "good-key"]`)})
+	if err != nil {
+		t.Fatalf("best-effort batch with one good key should succeed, got %v", err)
+	}
+	if res.CasesUpserted != 5 {
+		t.Errorf("CasesUpserted = %d, want 5 from the good key", res.CasesUpserted)
+	}
+}
+
+func TestKeyBatchAllOrNothingStopsAtFirstFailure(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	if _, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte(`["key-a", "key-b"]`)}); err == nil {
+		t.Fatal("expected the batch to fail under all_or_nothing")
+	}
+	if fb.casesUpserted != 0 {
+		t.Errorf("backend received %d cases, want 0", fb.casesUpserted)
+	}
+}
+
+func TestConcurrentFetchReassemblesPagesInOrder(t *testing.T) {
+	fb := newFakeBackends(t, 3, 2)
+	cfg.CdqFetchConcurrency = 3
+
+	// Page 2 is the slowest, so pages complete out of order; the upserts
+	// must still arrive in page order.
+	cdqHandler := fb.cdq.Config.Handler
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		cdqHandler.ServeHTTP(w, r)
+	})
+
+	var mu sync.Mutex
+	var order []string
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			var payload UpsertFraudCasesRequest
+			json.NewDecoder(r.Body).Decode(&payload)
+			mu.Lock()
+			for _, fc := range payload.FraudCases {
+				order = append(order, fc.CdlId)
+			}
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: time.Now().UnixMilli()})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if res.PagesFetched != 3 {
+		t.Errorf("PagesFetched = %d, want 3", res.PagesFetched)
+	}
+	want := []string{"case-1-0", "case-1-1", "case-2-0", "case-2-1", "case-3-0", "case-3-1"}
+	if len(order) != len(want) {
+		t.Fatalf("upserted %d cases, want %d", len(order), len(want))
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("upsert order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestAPIKeyFileOverridesBodyAndPicksUpRotation(t *testing.T) {
+	var mu sync.Mutex
+	var seenKeys []string
+	fb := newFakeBackends(t, 1, 2)
+	cdqHandler := fb.cdq.Config.Handler
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seenKeys = append(seenKeys, r.Header.Get("X-API-KEY"))
+		mu.Unlock()
+		cdqHandler.ServeHTTP(w, r)
+	})
+
+	keyFile := t.TempDir() + "/api-key"
+	if err := os.WriteFile(keyFile, []byte("file-key-1\n"), 0o600); err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+	cfg.CdqAPIKeyFile = keyFile
+
+	if _, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("body-key-ignored")}); err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+
+	// Rotate the mounted secret; the next message must use the new key.
+	if err := os.WriteFile(keyFile, []byte("file-key-2\n"), 0o600); err != nil {
+		t.Fatalf("rotating key file: %v", err)
+	}
+	if _, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("body-key-ignored")}); err != nil {
+		t.Fatalf("handleMessage after rotation: %v", err)
+	}
+
+	if len(seenKeys) != 2 || seenKeys[0] != "file-key-1" || seenKeys[1] != "file-key-2" {
+		t.Errorf("CDQ saw keys %v, want [file-key-1 file-key-2]", seenKeys)
+	}
+}
+
+func TestMessageBudgetCapsTotalDuration(t *testing.T) {
+	// Three slow pages at 150ms each would take ~450ms; a 250ms budget must
+	// cut the message off mid-way, no matter which stage it is in.
+	fb := newFakeBackends(t, 3, 2)
+	cfg.MessageBudget = 250 * time.Millisecond
+	cdqHandler := fb.cdq.Config.Handler
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		cdqHandler.ServeHTTP(w, r)
+	})
+
+	start := time.Now()
+	_, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatal("expected the message to fail once the budget ran out")
+	}
+	if elapsed > 600*time.Millisecond {
+		t.Errorf("message took %v, want well under the unbudgeted ~450ms+", elapsed)
+	}
+}
+
+func TestStreamModeUpsertsInBatches(t *testing.T) {
+	// 25 streamed cases at a page size of 10 must arrive as three upserts
+	// (10+10+5), with no reliance on paging metadata.
+	fb := newFakeBackends(t, 1, 0)
+	cfg.CdqResponseMode = cdqResponseModeStream
+	cfg.CdqPageSize = 10
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+		for i := 0; i < 25; i++ {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			fmt.Fprintf(w, `{"cdlId":"case-%d","bankCountryCode":"DE"}`, i)
+		}
+		w.Write([]byte("]"))
+	})
+
+	var mu sync.Mutex
+	var batchSizes []int
+	catHandler := fb.catenax.Config.Handler
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var payload UpsertFraudCasesRequest
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &payload)
+			mu.Lock()
+			batchSizes = append(batchSizes, len(payload.FraudCases))
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: time.Now().UnixMilli()})
+			return
+		}
+		catHandler.ServeHTTP(w, r)
+	})
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if res.CasesUpserted != 25 {
+		t.Errorf("CasesUpserted = %d, want 25", res.CasesUpserted)
+	}
+	want := []int{10, 10, 5}
+	if len(batchSizes) != len(want) {
+		t.Fatalf("upsert batches = %v, want %v", batchSizes, want)
+	}
+	for i := range want {
+		if batchSizes[i] != want[i] {
+			t.Fatalf("upsert batches = %v, want %v", batchSizes, want)
+		}
+	}
+}
+
+func TestRateLimitOverrideAppliesPerMessageLimiter(t *testing.T) {
+	cfg = loadConfig()
+
+	ctx, err := applyRateLimitOverride(context.Background(), amqp.Delivery{
+		Headers: amqp.Table{"x-cdq-rate-limit": 2.5},
+	})
+	if err != nil {
+		t.Fatalf("applyRateLimitOverride: %v", err)
+	}
+	limiter := cdqLimiterFrom(ctx)
+	if limiter == nil {
+		t.Fatal("no limiter in context after override")
+	}
+	if got := float64(limiter.Limit()); got != 2.5 {
+		t.Errorf("limiter rate = %v, want 2.5", got)
+	}
+
+	// Without the header the global limiter (nil here) is used unchanged.
+	ctx, err = applyRateLimitOverride(context.Background(), amqp.Delivery{})
+	if err != nil {
+		t.Fatalf("applyRateLimitOverride without header: %v", err)
+	}
+	if cdqLimiterFrom(ctx) != cdqLimiter {
+		t.Error("missing header must fall back to the global limiter")
+	}
+}
+
+func TestRateLimitOverrideRejectsBadValues(t *testing.T) {
+	cfg = loadConfig()
+	for _, v := range []interface{}{0.0, -1.0, 5000.0, "not-a-number", true} {
+		if _, err := applyRateLimitOverride(context.Background(), amqp.Delivery{
+			Headers: amqp.Table{"x-cdq-rate-limit": v},
+		}); err == nil {
+			t.Errorf("x-cdq-rate-limit=%v was accepted, want rejection", v)
+		}
+	}
+}
+
+func TestFutureCutoffSkipsDelete(t *testing.T) {
+	// A server clock two hours ahead would produce a cutoff that deletes
+	// everything the sync just wrote; the guard must skip the delete.
+	futureAt := time.Now().Add(2 * time.Hour)
+	fb := newFakeBackends(t, 1, 5)
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(UpsertFraudCasesResponse{UpdatedAt: futureAt.UnixMilli()})
+		case http.MethodDelete:
+			fb.mu.Lock()
+			fb.deleteCalls++
+			fb.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if fb.deleteCalls != 0 {
+		t.Errorf("delete was called %d times despite a future cutoff, want 0", fb.deleteCalls)
+	}
+	if res.CasesUpserted != 5 {
+		t.Errorf("CasesUpserted = %d, want 5 (upserts still happen)", res.CasesUpserted)
+	}
+}
+
+func TestSkipInactiveFiltersSoftDeletedCases(t *testing.T) {
+	fb := newFakeBackends(t, 1, 0)
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NumberOfPages: 1, FraudCases: []FraudCases{
+			{CdlId: "active-1"},
+			{CdlId: "gone-1", Status: "DELETED"},
+			{CdlId: "active-2", Status: "ACTIVE"},
+			{CdlId: "gone-2", Status: "inactive"},
+		}})
+	})
+	cfg.CdqSkipInactive = true
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if fb.casesUpserted != 2 {
+		t.Errorf("casesUpserted = %d, want 2 (only active cases)", fb.casesUpserted)
+	}
+	if res.CasesFiltered != 2 {
+		t.Errorf("CasesFiltered = %d, want 2", res.CasesFiltered)
+	}
+	if fb.deleteCalls != 1 {
+		t.Errorf("deleteCalls = %d, want 1 (cutoff delete still cleans up inactive cases)", fb.deleteCalls)
+	}
+}
+
+func TestDiffModeReportsWithoutWriting(t *testing.T) {
+	fb := newFakeBackends(t, 1, 0)
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NumberOfPages: 1, FraudCases: []FraudCases{
+			{CdlId: "new-1", BankCountryCode: "DE"},
+			{CdlId: "same-1", BankCountryCode: "DE"},
+		}})
+	})
+	gets := 0
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("diff mode issued a %s request", r.Method)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		gets++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CatenaxCasesResponse{FraudCases: []FraudCaseObj{
+			{CdlId: "same-1", CountryCode: "DE"},
+			{CdlId: "gone-1", CountryCode: "DE"},
+		}})
+	})
+	cfg.DiffMode = true
+	initTransform(cfg)
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if gets != 1 {
+		t.Errorf("Catenax GET calls = %d, want 1", gets)
+	}
+	if res.CasesUpserted != 0 || res.CasesSkipped != 2 {
+		t.Errorf("expected no upserts and 2 skipped cases, got %+v", res)
+	}
+}
+
+func TestDiffModeSkipsGracefullyWithoutGetEndpoint(t *testing.T) {
+	fb := newFakeBackends(t, 1, 1)
+	fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	cfg.DiffMode = true
+	initTransform(cfg)
+
+	if _, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")}); err != nil {
+		t.Fatalf("diff mode must not fail when the GET endpoint is unavailable: %v", err)
+	}
+}
+
+func TestTimeoutOverrideAppliesPerMessage(t *testing.T) {
+	cfg = loadConfig()
+	cfg.RequestTimeout = 10 * time.Second
+
+	ctx, err := applyTimeoutOverrides(context.Background(), amqp.Delivery{
+		Headers: amqp.Table{"x-timeout-fetch-ms": int64(60000)},
+	})
+	if err != nil {
+		t.Fatalf("applyTimeoutOverrides: %v", err)
+	}
+	if got := stageTimeout(ctx, stageFetch); got != time.Minute {
+		t.Errorf("fetch timeout = %v, want 1m", got)
+	}
+	// Stages without an override keep the configured default.
+	if got := stageTimeout(ctx, stageUpsert); got != 10*time.Second {
+		t.Errorf("upsert timeout = %v, want the 10s default", got)
+	}
+
+	// A message without headers is unaffected by another message's override.
+	other, err := applyTimeoutOverrides(context.Background(), amqp.Delivery{})
+	if err != nil {
+		t.Fatalf("applyTimeoutOverrides without headers: %v", err)
+	}
+	if got := stageTimeout(other, stageFetch); got != 10*time.Second {
+		t.Errorf("fetch timeout without override = %v, want the 10s default", got)
+	}
+}
+
+func TestTimeoutOverrideClampsAndValidates(t *testing.T) {
+	cfg = loadConfig()
+
+	ctx, err := applyTimeoutOverrides(context.Background(), amqp.Delivery{
+		Headers: amqp.Table{"x-timeout-delete-ms": int64(3600000)},
+	})
+	if err != nil {
+		t.Fatalf("applyTimeoutOverrides: %v", err)
+	}
+	if got := stageTimeout(ctx, stageDelete); got != maxTimeoutOverride {
+		t.Errorf("delete timeout = %v, want clamped to %v", got, maxTimeoutOverride)
+	}
+
+	for _, v := range []interface{}{int64(0), int64(-100), "not-a-number", true} {
+		if _, err := applyTimeoutOverrides(context.Background(), amqp.Delivery{
+			Headers: amqp.Table{"x-timeout-upsert-ms": v},
+		}); err == nil {
+			t.Errorf("x-timeout-upsert-ms=%v was accepted, want rejection", v)
+		}
+	}
+}
+
+func TestFingerprintsSkipUnchangedCasesOnSecondSync(t *testing.T) {
+	fb := newFakeBackends(t, 1, 3)
+	cfg.FingerprintStateDir = t.TempDir()
+	if err := initFingerprints(cfg); err != nil {
+		t.Fatalf("initFingerprints: %v", err)
+	}
+	defer func() { caseFingerprints = nil }()
+
+	res, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("first handleMessage: %v", err)
+	}
+	if fb.casesUpserted != 3 {
+		t.Fatalf("first sync casesUpserted = %d, want 3", fb.casesUpserted)
+	}
+	if res.CasesUnchanged != 0 {
+		t.Errorf("first sync CasesUnchanged = %d, want 0", res.CasesUnchanged)
+	}
+	if fb.deleteCalls != 1 {
+		t.Errorf("first sync deleteCalls = %d, want 1", fb.deleteCalls)
+	}
+
+	// The fake serves identical cases, so the second sync writes nothing and
+	// must also skip the cutoff delete — nothing refreshed the updatedAt of
+	// the unchanged cases.
+	res, err = handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("second handleMessage: %v", err)
+	}
+	if fb.casesUpserted != 3 {
+		t.Errorf("second sync upserted %d new cases, want 0", fb.casesUpserted-3)
+	}
+	if res.CasesUnchanged != 3 {
+		t.Errorf("second sync CasesUnchanged = %d, want 3", res.CasesUnchanged)
+	}
+	if fb.deleteCalls != 1 {
+		t.Errorf("second sync deleteCalls = %d, want still 1 (cutoff delete skipped)", fb.deleteCalls)
+	}
+}
+
+func TestStrictResponseValidationFailsOnMissingFields(t *testing.T) {
+	cases := []struct {
+		name        string
+		cdqBody     string
+		catenaxBody string
+		wantErr     string
+	}{
+		{
+			name:        "missing numberOfPages",
+			cdqBody:     `{"fraudCases":[{"cdlId":"c-1"}]}`,
+			catenaxBody: `{"updatedAt":1}`,
+			wantErr:     "numberOfPages",
+		},
+		{
+			name:        "missing fraudCases",
+			cdqBody:     `{"numberOfPages":1}`,
+			catenaxBody: `{"updatedAt":1}`,
+			wantErr:     "fraudCases",
+		},
+		{
+			name:        "upsert missing updatedAt",
+			cdqBody:     `{"numberOfPages":1,"fraudCases":[{"cdlId":"c-1"}]}`,
+			catenaxBody: `{}`,
+			wantErr:     "updatedAt",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fb := newFakeBackends(t, 1, 0)
+			fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tc.cdqBody))
+			})
+			fb.catenax.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tc.catenaxBody))
+			})
+			cfg.StrictResponseValidation = true
+
+			_, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("handleMessage error = %v, want mention of %q", err, tc.wantErr)
+			}
+			if isTransient(err) {
+				t.Errorf("missing-field error is transient, want permanent: %v", err)
+			}
+
+			// The same responses must pass with the flag off: missing fields
+			// decode to zero values as before.
+			cfg.StrictResponseValidation = false
+			if _, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")}); err != nil {
+				t.Errorf("handleMessage with validation off: %v", err)
+			}
+		})
+	}
+}
+
+func TestEmptyMiddlePageIsRefetched(t *testing.T) {
+	fb := newFakeBackends(t, 3, 0)
+	var mu sync.Mutex
+	hits := map[string]int{}
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		mu.Lock()
+		hits[page]++
+		attempt := hits[page]
+		mu.Unlock()
+
+		cases := []FraudCases{{CdlId: "case-" + page, BankCountryCode: "DE"}}
+		// Page 2 is erroneously empty on its first read and healthy on the
+		// refetch, simulating a transient server-side filtering bug.
+		if page == "2" && attempt == 1 {
+			cases = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NumberOfPages: 3, FraudCases: cases})
+	})
+	cfg.CdqRetryEmptyPages = true
+
+	_, err := handleMessage(context.Background(), amqp.Delivery{Body: []byte("test-key")})
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if fb.casesUpserted != 3 {
+		t.Errorf("casesUpserted = %d, want 3 (page 2 recovered by refetch)", fb.casesUpserted)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["2"] != 2 {
+		t.Errorf("page 2 fetched %d times, want 2", hits["2"])
+	}
+	if hits["3"] != 1 {
+		t.Errorf("page 3 fetched %d times, want 1 (no refetch for healthy pages)", hits["3"])
+	}
+}
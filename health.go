@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ready reflects whether the consumer is registered and able to process
+// messages; /readyz reports it.
+var ready atomic.Bool
+
+func setReady(v bool) {
+	ready.Store(v)
+}
+
+// firstConsumeConfirmed reports whether this replica has proven it can
+// actually consume: it either received-and-acked a delivery or found the
+// queue empty at registration. With READINESS_REQUIRE_FIRST_MESSAGE, /readyz
+// holds back until then so a blue/green cutover never routes to a pod that
+// registered fine but cannot process.
+var firstConsumeConfirmed atomic.Bool
+
+func markConsumeConfirmed(how string) {
+	if !firstConsumeConfirmed.Swap(true) && cfg.ReadinessRequireFirstMessage {
+		log.WithField("via", how).Info("Consume capability confirmed, reporting ready")
+	}
+}
+
+// startHealthServer serves liveness, readiness, metrics and the admin
+// endpoints on the configured health port. It blocks, so run it in a
+// goroutine.
+func startHealthServer(cfg *Config) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if pauser.isPaused() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "paused")
+			return
+		}
+		if !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if cfg.ReadinessRequireFirstMessage && !firstConsumeConfirmed.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "awaiting first delivery")
+			return
+		}
+		// Opt-in: reporting a backlogged queue as not-ready lets an HPA keyed
+		// on readiness spin up more replicas.
+		if cfg.QueueDepthFailReady && fallingBehind.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "queue backlogged")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	// Exemplars are only emitted in the OpenMetrics exposition format, so the
+	// negotiation is switched on together with the feature.
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: cfg.TraceExemplars,
+	}))
+	mux.HandleFunc("/sync", handleAdminSync)
+	mux.HandleFunc("/pause", handleAdminPause)
+	mux.HandleFunc("/resume", handleAdminResume)
+	mux.HandleFunc("/errors", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recentErrors.snapshot())
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(redactedConfig(cfg))
+	})
+
+	addr := fmt.Sprintf(":%d", cfg.HealthPort)
+	log.WithField("addr", addr).Info("Starting health server")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.WithError(err).Error("Health server stopped")
+	}
+}
+
+// adminAuthorized enforces the shared preconditions of every admin endpoint:
+// POST only, ADMIN_TOKEN configured, and a matching X-Admin-Token header. It
+// writes the error response itself and reports whether to proceed.
+func adminAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if cfg.AdminToken == "" {
+		http.Error(w, "admin endpoint disabled, set ADMIN_TOKEN to enable", http.StatusForbidden)
+		return false
+	}
+	if r.Header.Get("X-Admin-Token") != cfg.AdminToken {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleAdminPause quiesces consumption; deliveries stay on the broker until
+// /resume. Idempotent, so maintenance scripts can call it blindly.
+func handleAdminPause(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r) {
+		return
+	}
+	pauser.pause("admin request")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": true})
+}
+
+// handleAdminResume is the counterpart to handleAdminPause.
+func handleAdminResume(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r) {
+		return
+	}
+	pauser.resume("admin request")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": false})
+}
+
+// handleAdminSync triggers a synchronous sync for the API key in the request
+// body, bypassing RabbitMQ. It is meant for manual re-syncs during incidents
+// and is guarded by the ADMIN_TOKEN shared secret.
+func handleAdminSync(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil || strings.TrimSpace(string(body)) == "" {
+		http.Error(w, "request body must contain an API key", http.StatusBadRequest)
+		return
+	}
+
+	log.Info("Admin-triggered sync starting")
+	result, syncErr := handleMessage(context.Background(), amqp.Delivery{Body: body})
+
+	summary := map[string]interface{}{
+		"duration":      result.Duration.String(),
+		"pagesFetched":  result.PagesFetched,
+		"casesUpserted": result.CasesUpserted,
+		"casesSkipped":  result.CasesSkipped,
+		"deleteCutoff":  result.DeleteCutoff.UTC().Format(time.RFC3339),
+		"success":       syncErr == nil,
+	}
+	status := http.StatusOK
+	if syncErr != nil {
+		summary["error"] = syncErr.Error()
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(summary)
+}
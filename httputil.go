@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// requestTimeout returns the configured request timeout with the optional
+// ± percentage jitter applied. When many concurrent requests share one fixed
+// timeout and the backend stalls, they all time out and retry in the same
+// instant; spreading the deadlines spreads the retry load. A jitter of 0
+// (the default) keeps the fixed timeout.
+func requestTimeout() time.Duration {
+	base := cfg.RequestTimeout
+	if cfg.TimeoutJitterPct <= 0 {
+		return base
+	}
+	span := float64(base) * cfg.TimeoutJitterPct / 100
+	return base + time.Duration((rand.Float64()*2-1)*span)
+}
+
+// Timeout stages: each outbound call belongs to one, so per-message timeout
+// overrides can target fetches, upserts and deletes independently.
+const (
+	stageFetch  = "fetch"
+	stageUpsert = "upsert"
+	stageDelete = "delete"
+)
+
+// timeoutOverrideKey carries per-message stage timeout overrides through the
+// context; slow tenants get longer deadlines via message headers without
+// raising REQUEST_TIMEOUT_MS globally.
+type timeoutOverrideKey struct{}
+
+func withTimeoutOverrides(ctx context.Context, overrides map[string]time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutOverrideKey{}, overrides)
+}
+
+// stageTimeout returns the timeout for one outbound call: the message's
+// override for the stage when present (exact, no jitter — the publisher
+// asked for that value), otherwise the jittered configured default.
+func stageTimeout(ctx context.Context, stage string) time.Duration {
+	if overrides, ok := ctx.Value(timeoutOverrideKey{}).(map[string]time.Duration); ok {
+		if d, ok := overrides[stage]; ok {
+			return d
+		}
+	}
+	return requestTimeout()
+}
+
+// deleteTimeout returns the deadline for one delete request. The message's
+// stage override still wins (the publisher asked for that value), then
+// CATENAX_DELETE_TIMEOUT_MS, then the shared jittered default. Cutoff deletes
+// scan the whole dataset by updatedAt and can legitimately run far longer
+// than an upsert on tenants with large old-data sets; a timeout here is
+// classified transient by wrapRequestError, so the message requeues and the
+// delete is retried instead of leaving stale data behind.
+func deleteTimeout(ctx context.Context) time.Duration {
+	if overrides, ok := ctx.Value(timeoutOverrideKey{}).(map[string]time.Duration); ok {
+		if d, ok := overrides[stageDelete]; ok {
+			return d
+		}
+	}
+	if cfg.CatenaxDeleteTimeout > 0 {
+		return cfg.CatenaxDeleteTimeout
+	}
+	return requestTimeout()
+}
+
+// randomHex16 returns 16 random bytes hex-encoded, used for request-scoped
+// identifiers such as idempotency keys and sync batch ids.
+func randomHex16() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// wrapRequestError classifies an outbound request failure. A cancelled
+// context (shutdown) and a deadline-exceeded (timeout) both surface as
+// transient errors, but they are counted separately and the caller treats
+// cancellations as shutdown noise rather than endpoint failures. DNS and
+// connection-refused failures get their own reasons and spelled-out
+// messages: during an incident, "host not found" points at DNS or a wrong
+// URL, "connection refused" at a down service — a raw net error string
+// points at neither.
+func wrapRequestError(endpoint, what string, err error) error {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		requestCancellations.WithLabelValues(endpoint).Inc()
+		return transient("canceled", "%s canceled: %w", what, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		requestTimeouts.WithLabelValues(endpoint).Inc()
+		return transient("timeout", "%s timed out: %w", what, err)
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsNotFound {
+			return transient("dns", "%s failed: host %q not found — check the endpoint URL and cluster DNS: %w", what, dnsErr.Name, err)
+		}
+		return transient("dns", "%s failed: DNS lookup for %q failed — likely a resolver or network problem: %w", what, dnsErr.Name, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return transient("conn_refused", "%s failed: connection refused — the host resolved but nothing is listening: %w", what, err)
+	default:
+		return transient("network", "%s failed: %w", what, err)
+	}
+}
+
+// ensureJSONResponse rejects responses that are about to be JSON-decoded but
+// do not declare a JSON content type. A proxy or gateway serving an HTML
+// error page then fails with the actual content type and body instead of a
+// confusing parse error.
+func ensureJSONResponse(resp *http.Response, body []byte) error {
+	contentType := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") {
+		return nil
+	}
+	return fmt.Errorf("expected application/json response, got %q: %s", contentType, truncateBody(body, 256))
+}
+
+// requireResponseFields enforces STRICT_RESPONSE_VALIDATION: every listed
+// top-level key must be present in the JSON body. Without the flag, missing
+// fields silently decode to their zero values (empty ids, epoch-zero
+// timestamps) and surface as subtle downstream bugs; with it, the message
+// fails loudly at the contract boundary. A missing field is a permanent
+// error — retrying will not make the server send it.
+func requireResponseFields(body []byte, what string, fields ...string) error {
+	if !cfg.StrictResponseValidation {
+		return nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return fmt.Errorf("%s is not a JSON object: %w", what, err)
+	}
+	for _, field := range fields {
+		if _, ok := raw[field]; !ok {
+			return fmt.Errorf("%s is missing required field %q", what, field)
+		}
+	}
+	return nil
+}
+
+// unmarshalUseNumber decodes JSON like json.Unmarshal but keeps numbers as
+// json.Number instead of float64. Every decode into interface{} (maps, schema
+// validation) must go through it: float64 silently rounds integers above 2^53,
+// which epoch-millis timestamps will exceed well within their useful life.
+func unmarshalUseNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// truncateBody shortens a response body for inclusion in error messages.
+func truncateBody(body []byte, max int) string {
+	if len(body) <= max {
+		return string(body)
+	}
+	return string(body[:max]) + "... (truncated)"
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutJitter(t *testing.T) {
+	cfg = loadConfig()
+	cfg.RequestTimeout = 10 * time.Second
+
+	cfg.TimeoutJitterPct = 0
+	if got := requestTimeout(); got != 10*time.Second {
+		t.Errorf("timeout without jitter = %v, want exactly 10s", got)
+	}
+
+	cfg.TimeoutJitterPct = 20
+	for i := 0; i < 100; i++ {
+		got := requestTimeout()
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("jittered timeout %v outside ±20%% of 10s", got)
+		}
+	}
+}
+
+func TestUnmarshalUseNumberKeepsInt64Precision(t *testing.T) {
+	// 2^53+1 is the first integer float64 cannot represent; epoch-millis
+	// values this large are valid dateOfAttack inputs.
+	const big = int64(9007199254740993)
+	var doc map[string]interface{}
+	if err := unmarshalUseNumber([]byte(`{"dateOfAttack": 9007199254740993}`), &doc); err != nil {
+		t.Fatalf("unmarshalUseNumber: %v", err)
+	}
+	num, ok := doc["dateOfAttack"].(json.Number)
+	if !ok {
+		t.Fatalf("dateOfAttack decoded as %T, want json.Number", doc["dateOfAttack"])
+	}
+	got, err := num.Int64()
+	if err != nil {
+		t.Fatalf("Int64: %v", err)
+	}
+	if got != big {
+		t.Errorf("decoded %d, want %d (precision lost)", got, big)
+	}
+}
+
+func TestWrapRequestErrorClassifiesNetworkFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		reason string
+	}{
+		{
+			name:   "host not found",
+			err:    &url.Error{Op: "Get", URL: "https://catenax.invalid", Err: &net.DNSError{Name: "catenax.invalid", IsNotFound: true}},
+			reason: "dns",
+		},
+		{
+			name:   "resolver failure",
+			err:    &net.DNSError{Name: "catenax.example", IsTimeout: true},
+			reason: "dns",
+		},
+		{
+			name:   "connection refused",
+			err:    &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}},
+			reason: "conn_refused",
+		},
+		{
+			name:   "deadline exceeded",
+			err:    &url.Error{Op: "Get", URL: "https://catenax.example", Err: context.DeadlineExceeded},
+			reason: "timeout",
+		},
+		{
+			name:   "other network error",
+			err:    errors.New("connection reset by peer"),
+			reason: "network",
+		},
+	} {
+		wrapped := wrapRequestError("catenax_upsert", "Catenax upsert request", tc.err)
+		if !isTransient(wrapped) {
+			t.Errorf("%s: expected transient classification, got %v", tc.name, wrapped)
+		}
+		if got := transientReason(wrapped); got != tc.reason {
+			t.Errorf("%s: reason = %q, want %q", tc.name, got, tc.reason)
+		}
+	}
+}
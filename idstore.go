@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// idStore persists the set of CdlIds from each tenant's previous sync so
+// explicit delete mode can compute which cases disappeared upstream. One
+// JSON file per tenant lives in DELETE_STATE_DIR, keyed by a hash of the
+// API key (the key itself never touches disk). The directory must survive
+// restarts — mount a persistent volume in deployments using explicit mode.
+type idStore struct {
+	dir string
+}
+
+// deleteIDStore is set up at startup when DELETE_MODE=explicit.
+var deleteIDStore *idStore
+
+func initIDStore(cfg *Config) error {
+	if cfg.DeleteMode != deleteModeExplicit {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.DeleteStateDir, 0o755); err != nil {
+		return err
+	}
+	deleteIDStore = &idStore{dir: cfg.DeleteStateDir}
+	return nil
+}
+
+// tenantHash derives the on-disk identifier for a tenant from its API key.
+func tenantHash(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *idStore) path(tenant string) string {
+	return filepath.Join(s.dir, tenant+".json")
+}
+
+func (s *idStore) load(tenant string) (map[string]bool, error) {
+	data, err := os.ReadFile(s.path(tenant))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set, nil
+}
+
+func (s *idStore) save(tenant string, ids []string) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	// Write-and-rename so a crash mid-save never leaves a torn id set.
+	tmp := s.path(tenant) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(tenant))
+}
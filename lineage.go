@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// lineagePub publishes the CdlIds each successful sync touched when
+// RMQ_LINEAGE_EXCHANGE is configured; nil otherwise. Like the result
+// publisher it owns its own channel in confirm mode, so a dropped lineage
+// event is detected instead of silently lost.
+var lineagePub *lineagePublisher
+
+type lineagePublisher struct {
+	mu       sync.Mutex
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func initLineagePublisher(conn *amqp.Connection) error {
+	if cfg.LineageExchange == "" {
+		return nil
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := channel.Confirm(false); err != nil {
+		return err
+	}
+	lineagePub = &lineagePublisher{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+	}
+	return nil
+}
+
+// lineageEvent is one chunk of CdlIds a sync touched. The sync batch id
+// correlates chunks (and the audit log) back to the run; chunk/totalChunks
+// let the consumer detect a partial set.
+type lineageEvent struct {
+	SyncBatchID string   `json:"syncBatchId"`
+	Tenant      string   `json:"tenant"`
+	Action      string   `json:"action"`
+	CdlIds      []string `json:"cdlIds"`
+	Chunk       int      `json:"chunk"`
+	TotalChunks int      `json:"totalChunks"`
+}
+
+// publishLineage emits the upserted and deleted id lists of one finished
+// sync, chunked to LINEAGE_CHUNK_SIZE so no event exceeds broker frame
+// limits. Publishing problems are logged but never fail the sync — lineage
+// is an observer of the pipeline, not a participant.
+func publishLineage(batchID, tenant string, res *ProcessResult) {
+	if lineagePub == nil || dryRun() {
+		return
+	}
+	publishAction(batchID, tenant, "upserted", res.UpsertedIDs)
+	publishAction(batchID, tenant, "deleted", res.DeletedIDs)
+}
+
+func publishAction(batchID, tenant, action string, ids []string) {
+	chunks := chunkIDs(ids, cfg.LineageChunkSize)
+	for i, chunk := range chunks {
+		body, err := json.Marshal(lineageEvent{
+			SyncBatchID: batchID,
+			Tenant:      tenant,
+			Action:      action,
+			CdlIds:      chunk,
+			Chunk:       i + 1,
+			TotalChunks: len(chunks),
+		})
+		if err != nil {
+			log.WithError(err).Error("Failed to marshal lineage event")
+			return
+		}
+
+		lineagePub.mu.Lock()
+		err = lineagePub.channel.Publish(cfg.LineageExchange, cfg.LineageRoutingKey, false, false, amqp.Publishing{
+			ContentType: "application/json",
+			Timestamp:   time.Now(),
+			Body:        body,
+		})
+		if err == nil {
+			if confirmation, ok := <-lineagePub.confirms; !ok || !confirmation.Ack {
+				log.WithField("action", action).Error("Broker did not confirm lineage event")
+			}
+		}
+		lineagePub.mu.Unlock()
+		if err != nil {
+			log.WithError(err).WithField("action", action).Error("Failed to publish lineage event")
+			return
+		}
+	}
+}
+
+// chunkIDs splits ids into consecutive slices of at most size elements.
+func chunkIDs(ids []string, size int) [][]string {
+	if len(ids) == 0 {
+		return nil
+	}
+	if size <= 0 {
+		return [][]string{ids}
+	}
+	var chunks [][]string
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunks = append(chunks, ids[start:end])
+	}
+	return chunks
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestChunkIDs(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+
+	chunks := chunkIDs(ids, 2)
+	if len(chunks) != 3 {
+		t.Fatalf("chunkIDs(5 ids, size 2) = %d chunks, want 3", len(chunks))
+	}
+	if len(chunks[0]) != 2 || len(chunks[2]) != 1 {
+		t.Errorf("chunk sizes = %d,%d,%d, want 2,2,1", len(chunks[0]), len(chunks[1]), len(chunks[2]))
+	}
+
+	if got := chunkIDs(nil, 2); got != nil {
+		t.Errorf("chunkIDs(nil) = %v, want nil", got)
+	}
+
+	// A non-positive size must not loop forever or drop ids.
+	if got := chunkIDs(ids, 0); len(got) != 1 || len(got[0]) != 5 {
+		t.Errorf("chunkIDs(size 0) = %v, want one chunk with all ids", got)
+	}
+}
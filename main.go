@@ -0,0 +1,704 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// cfg is the process-wide configuration, loaded once in main before anything
+// else touches it.
+var cfg *Config
+
+// httpClient is shared by all CDQ and Catenax calls so that connection
+// pooling works across messages.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 10,
+	},
+}
+
+// setTestTransport swaps the shared client's RoundTripper and returns a
+// restore function. It exists so tests can serve canned responses and capture
+// requests without an httptest server; production code never calls it and
+// always runs on the pooled Transport above.
+func setTestTransport(rt http.RoundTripper) (restore func()) {
+	prev := httpClient.Transport
+	httpClient.Transport = rt
+	return func() { httpClient.Transport = prev }
+}
+
+// Exit codes for the different startup failure classes. The supervisor uses
+// them to decide between restarting and alerting, so keep them stable.
+const (
+	exitConfig = 2
+	exitSentry = 3
+	exitAMQP   = 4
+	exitQueue  = 5
+	exitOAuth  = 6
+)
+
+// sentryReady records that Sentry initialization succeeded. Failures before
+// that point (env validation, Sentry init itself) can only be logged.
+var sentryReady bool
+
+// failOnError logs the error and terminates the worker with the given exit
+// code. It is only meant for startup failures and fatal runtime conditions
+// where there is nothing sensible left to do. Those are exactly the ones we
+// want alerted on, so once Sentry is up the error is captured and flushed
+// before exiting.
+func failOnError(err error, msg string, exitCode int) {
+	if err != nil {
+		log.WithError(err).Error(msg)
+		if sentryReady {
+			sentry.CurrentHub().CaptureException(fmt.Errorf("%s: %w", msg, err))
+			sentry.Flush(2 * time.Second)
+		}
+		os.Exit(exitCode)
+	}
+}
+
+// configureTransport applies TLS options to the shared Transport.
+// INSECURE_SKIP_TLS_VERIFY exists solely for local runs against self-signed
+// mocks and is refused outright in production.
+func configureTransport(cfg *Config) {
+	if !cfg.InsecureSkipTLSVerify {
+		return
+	}
+	if cfg.SentryEnvironment == "production" {
+		failOnError(fmt.Errorf("INSECURE_SKIP_TLS_VERIFY must not be set when SENTRY_ENVIRONMENT=production"),
+			"Refusing insecure TLS configuration", exitConfig)
+	}
+	httpClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	log.Warn("!!! TLS CERTIFICATE VERIFICATION IS DISABLED — local/dev use only !!!")
+}
+
+func setupLogging(cfg *Config) {
+	log.SetFormatter(logFormatter(cfg.LogFormat))
+	log.AddHook(deployFieldsHook{})
+	level, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		log.WithField("logLevel", cfg.LogLevel).Warn("Unknown LOG_LEVEL, falling back to info")
+		level = log.InfoLevel
+	}
+	log.SetLevel(level)
+}
+
+// deployFieldsHook stamps every log entry with the region/zone of this
+// replica, mirroring the constant labels on the metrics, so logs and
+// dashboards slice along the same dimensions.
+type deployFieldsHook struct{}
+
+func (deployFieldsHook) Levels() []log.Level { return log.AllLevels }
+
+func (deployFieldsHook) Fire(entry *log.Entry) error {
+	entry.Data["region"] = deployRegion
+	entry.Data["zone"] = deployZone
+	return nil
+}
+
+// logFormatter maps LOG_FORMAT to a logrus formatter. The structured fields
+// are identical across formats — only the serialization differs — so
+// instrumentation never needs to care which pipeline consumes the logs.
+func logFormatter(format string) log.Formatter {
+	switch format {
+	case logFormatLogfmt:
+		// logrus's text formatter emits key=value pairs when colors are off,
+		// which is exactly the logfmt the legacy pipeline parses.
+		return &log.TextFormatter{DisableColors: true, FullTimestamp: true}
+	case logFormatText:
+		return &log.TextFormatter{FullTimestamp: true}
+	default:
+		return &log.JSONFormatter{}
+	}
+}
+
+// sentryTransport optionally replaces the transport Sentry sends events
+// through. Production leaves it nil (the SDK's default async HTTP
+// transport); tests inject a capturing fake so the error-reporting paths
+// can be asserted without network.
+var sentryTransport sentry.Transport
+
+func initSentry(cfg *Config) error {
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         cfg.SentryDSN,
+		Environment: cfg.SentryEnvironment,
+		Transport:   sentryTransport,
+	})
+	if err != nil {
+		return err
+	}
+	sentry.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag("region", deployRegion)
+		scope.SetTag("zone", deployZone)
+	})
+	return nil
+}
+
+func main() {
+	// --version must work without any config or connections so deploy
+	// scripts can assert the artifact before wiring up an environment.
+	versionFlag := flag.Bool("version", false, "print version information and exit")
+	flag.Parse()
+	if *versionFlag {
+		printVersion()
+		os.Exit(0)
+	}
+
+	cfg = loadConfig()
+	failOnError(validateEnvVars(), "Missing required environment variables", exitConfig)
+	setupLogging(cfg)
+	failOnError(validateEndpointConsistency(cfg), "Inconsistent Catenax endpoints", exitConfig)
+	log.WithFields(log.Fields(redactedConfig(cfg))).Info("Effective configuration")
+	configureTransport(cfg)
+	// Sentry is optional: local and partial-feature deployments run without a
+	// DSN, in which case capture calls degrade to no-ops.
+	if cfg.SentryDSN != "" {
+		failOnError(initSentry(cfg), "Failed to initialize Sentry", exitSentry)
+		sentryReady = true
+		defer sentry.Flush(2 * time.Second)
+	} else {
+		log.Info("SENTRY_DSN not set, error reporting disabled")
+	}
+	initCdqLimiter(cfg)
+	initFleetLimiter(cfg)
+	failOnError(initDailyBudget(cfg), "Failed to initialize the daily CDQ budget", exitConfig)
+	initBreakers(cfg)
+	initCatenaxWriteLimit(cfg)
+	initTenantGate(cfg)
+	initMemoryGuard(cfg)
+	failOnError(initMessageSchema(cfg), "Failed to load message schema", exitConfig)
+	failOnError(initIDStore(cfg), "Failed to initialize delete id store", exitConfig)
+	failOnError(initFingerprints(cfg), "Failed to initialize fingerprint store", exitConfig)
+	failOnError(initTransform(cfg), "Failed to select transform profile", exitConfig)
+	initDedup(cfg)
+	initCoalescer(cfg)
+	initErrorBuffer(cfg)
+	failOnError(initAudit(cfg), "Failed to open audit log", exitConfig)
+	failOnError(initFailedCases(cfg), "Failed to open failed-cases file", exitConfig)
+	failOnError(initOAuth(context.Background(), cfg), "Failed to acquire OAuth token", exitOAuth)
+	watchReload()
+
+	if cfg.RunMode == runModeReplay {
+		failures := runReplay(cfg.ReplayFile)
+		closeFailedCases()
+		closeAudit()
+		pushMetrics()
+		if failures > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if cfg.RunMode == runModeReplayFailed {
+		failures := runFailedCaseReplay(cfg.FailedCasesPath)
+		closeAudit()
+		pushMetrics()
+		os.Exit(failures)
+	}
+
+	go startHealthServer(cfg)
+
+	if cfg.StartupPingAPIs {
+		failOnError(waitForAPIs(), "CDQ/Catenax not reachable within startup wait", exitConfig)
+	}
+	conn, err := dialWithStartupWait()
+	failOnError(err, "Failed to connect to RabbitMQ", exitAMQP)
+	defer conn.Close()
+	failOnError(initResultPublisher(conn), "Failed to set up result publisher", exitAMQP)
+	failOnError(initLineagePublisher(conn), "Failed to set up lineage publisher", exitAMQP)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	startMetricsPusher(ctx)
+	startMemoryGuard(ctx)
+	startQueueDepthMonitor(ctx, conn)
+	startCertExpiryCheck(ctx, cfg)
+
+	err = subscribe(ctx, conn)
+
+	log.Info("Shutting down")
+	closeFailedCases()
+	closeAudit()
+	pushMetrics()
+	// A dead channel outside shutdown must not look like a clean exit, or
+	// the supervisor restarts nothing and nobody gets alerted.
+	failOnError(err, "Consumer stopped: AMQP channel died", exitAMQP)
+	os.Exit(0)
+}
+
+// dialWithStartupWait connects to RabbitMQ, retrying for up to STARTUP_WAIT_MS
+// on cold starts where the broker comes up in parallel with the worker.
+// With the wait at its zero default the first failure is fatal, matching the
+// historical behavior.
+func dialWithStartupWait() (*amqp.Connection, error) {
+	conn, err := amqp.Dial(cfg.AmqpURL)
+	if err == nil || cfg.StartupWait <= 0 {
+		return conn, err
+	}
+	deadline := time.Now().Add(cfg.StartupWait)
+	for {
+		log.WithError(err).WithField("deadline", deadline.UTC().Format(time.RFC3339)).Warn("RabbitMQ not reachable yet, waiting")
+		time.Sleep(2 * time.Second)
+		conn, err = amqp.Dial(cfg.AmqpURL)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+	}
+}
+
+// waitForAPIs waits until CDQ and Catenax answer HTTP at all, sharing the
+// STARTUP_WAIT_MS budget. Any status code counts as reachable — this is a
+// liveness probe for the endpoints, not an auth check.
+func waitForAPIs() error {
+	deadline := time.Now().Add(cfg.StartupWait)
+	for _, endpoint := range []struct{ name, url string }{
+		{"cdq", cfg.CdqAPIURL},
+		{"catenax", cfg.CatenaxAPIURL},
+	} {
+		for {
+			err := pingEndpoint(endpoint.url)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s not reachable at %s: %w", endpoint.name, endpoint.url, err)
+			}
+			log.WithError(err).WithField("endpoint", endpoint.name).Warn("Dependency not reachable yet, waiting")
+			time.Sleep(2 * time.Second)
+		}
+	}
+	return nil
+}
+
+func pingEndpoint(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// queueArgs returns the declare arguments derived from config. Quorum queues
+// need an explicit x-queue-type at declare time; everything else the worker
+// declares (durable, single-active-consumer) is supported by both types, and
+// the classic-only features (auto-delete, exclusive queues) are never used
+// here, so no other argument needs gating.
+func queueArgs() amqp.Table {
+	args := amqp.Table{}
+	if cfg.QueueType == queueTypeQuorum {
+		args["x-queue-type"] = queueTypeQuorum
+	}
+	if cfg.SingleActiveConsumer {
+		// Broker-side single-active-consumer: all replicas may subscribe but
+		// the broker delivers to one at a time, preserving ordering per queue.
+		args["x-single-active-consumer"] = true
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// deliveryCount reads the broker-maintained x-delivery-count header that
+// quorum queues attach to redelivered messages. Classic queues never send
+// it, and quorum queues omit it on the first delivery, so zero means "first
+// attempt" either way.
+func deliveryCount(msg amqp.Delivery) int64 {
+	switch v := msg.Headers["x-delivery-count"].(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return 0
+}
+
+func isAccessRefused(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == amqp.AccessRefused
+}
+
+// consumerTag returns the tag shown in the RabbitMQ management UI for this
+// worker, so operators can tell pods apart. Defaults to hostname and pid.
+func consumerTag() string {
+	if cfg.ConsumerTag != "" {
+		return cfg.ConsumerTag
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("fraud-worker-%s-%d", host, os.Getpid())
+}
+
+// subscribe sets up the channel and consumer and processes deliveries until
+// the context is cancelled or the delivery channel closes. When another
+// replica already holds an exclusive consumer, it backs off and retries
+// instead of crash-looping. It returns nil on a shutdown-driven stop and an
+// error when the delivery channel closed underneath a live context — i.e.
+// the channel or connection died and the worker must not exit cleanly.
+func subscribe(ctx context.Context, conn *amqp.Connection) error {
+	for {
+		channel, err := conn.Channel()
+		failOnError(err, "Failed to open a channel", exitAMQP)
+
+		queue, err := channel.QueueDeclare(
+			cfg.QueueName, // name
+			true,          // durable
+			false,         // auto-delete
+			false,         // exclusive
+			false,         // no-wait
+			queueArgs(),   // args
+		)
+		failOnError(err, "Failed to declare queue", exitQueue)
+
+		err = channel.Qos(cfg.PrefetchCount, 0, false)
+		failOnError(err, "Failed to set QoS", exitAMQP)
+
+		tag := consumerTag()
+		msgs, err := channel.Consume(
+			queue.Name,            // queue
+			tag,                   // consumer tag
+			false,                 // auto-ack
+			cfg.ExclusiveConsumer, // exclusive
+			false,                 // no-local
+			false,                 // no-wait
+			nil,                   // args
+		)
+		if err != nil {
+			if isAccessRefused(err) {
+				channel.Close()
+				log.WithError(err).Warn("Queue already has an exclusive consumer, backing off")
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(5 * time.Second):
+					continue
+				}
+			}
+			failOnError(err, "Failed to register consumer", exitAMQP)
+		}
+
+		setReady(true)
+		// An empty queue at registration is as much proof of consume
+		// capability as a delivery would be — there is simply nothing to
+		// receive; otherwise the first successful ack confirms it.
+		if cfg.ReadinessRequireFirstMessage && queue.Messages == 0 {
+			markConsumeConfirmed("empty queue")
+		}
+		log.WithFields(log.Fields{"queue": queue.Name, "consumerTag": tag}).Info("Waiting for messages")
+
+		// sem bounds total in-flight messages; messageGate additionally bounds
+		// them per tenant so one tenant's burst cannot claim every slot. With
+		// MAX_CONCURRENT_MESSAGES at its default of 1 this degenerates to the
+		// historical sequential behavior.
+		sem := make(chan struct{}, cfg.MaxConcurrentMessages)
+		var wg sync.WaitGroup
+
+		for {
+			// While paused, stop taking deliveries; prefetched ones wait in
+			// the channel and the rest stay on the broker.
+			if err := pauser.wait(ctx); err != nil {
+				setReady(false)
+				drainDeliveries(channel, tag, msgs)
+				wg.Wait()
+				return nil
+			}
+			// How long the consumer sits here waiting for a delivery is the
+			// idle half of the capacity picture; messageDuration is the busy
+			// half.
+			waitStart := time.Now()
+			select {
+			case <-ctx.Done():
+				setReady(false)
+				drainDeliveries(channel, tag, msgs)
+				wg.Wait()
+				return nil
+			case msg, ok := <-msgs:
+				if !ok {
+					setReady(false)
+					wg.Wait()
+					if ctx.Err() != nil {
+						// The connection came down as part of shutdown.
+						log.Warn("Delivery channel closed during shutdown")
+						return nil
+					}
+					return errors.New("delivery channel closed outside shutdown")
+				}
+				consumerIdle.Observe(time.Since(waitStart).Seconds())
+				dispatch := func(msg amqp.Delivery) {
+					select {
+					case sem <- struct{}{}:
+					case <-ctx.Done():
+						nackDelivery(msg, true)
+						return
+					}
+					wg.Add(1)
+					go func(msg amqp.Delivery) {
+						defer wg.Done()
+						defer func() { <-sem }()
+						tenant := deliveryTenant(msg)
+						if err := messageGate.acquire(ctx, tenant); err != nil {
+							// Shutdown while waiting for the tenant slot: hand the
+							// message back instead of processing past cancellation.
+							nackDelivery(msg, true)
+							return
+						}
+						defer messageGate.release(tenant)
+						if release := memGuard.limitSlot(ctx); release != nil {
+							defer release()
+						}
+						processDelivery(ctx, msg)
+					}(msg)
+				}
+				if messageCoalescer != nil {
+					messageCoalescer.offer(ctx, msg, deliveryTenant(msg), dispatch)
+					continue
+				}
+				dispatch(msg)
+			}
+		}
+	}
+}
+
+// deliveryTenant derives the fairness key for a delivery. Malformed messages
+// (which handleMessage will reject anyway) and key batches fall back to
+// hashing the raw body so they still occupy exactly one slot.
+func deliveryTenant(msg amqp.Delivery) string {
+	key, err := extractAPIKey(msg)
+	if err != nil {
+		return tenantHash(string(msg.Body))
+	}
+	return tenantHash(key)
+}
+
+// consumerCanceler is the slice of amqp.Channel drainDeliveries needs; an
+// interface so shutdown draining is testable without a broker.
+type consumerCanceler interface {
+	Cancel(consumer string, noWait bool) error
+}
+
+// drainDeliveries cancels the consumer and requeues every delivery that was
+// prefetched but never processed. With RMQ_PREFETCH_COUNT above one, a
+// shutdown would otherwise strand the buffered messages until the connection
+// dies; an explicit Nack hands them straight back for another replica.
+func drainDeliveries(channel consumerCanceler, tag string, msgs <-chan amqp.Delivery) {
+	requeue := func(msg amqp.Delivery) {
+		nackDelivery(msg, true)
+	}
+
+	if err := channel.Cancel(tag, false); err != nil {
+		log.WithError(err).Warn("Failed to cancel consumer during shutdown")
+		// Without a confirmed cancel the broker may still be pushing
+		// deliveries, so only drain what is already buffered.
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				requeue(msg)
+			default:
+				return
+			}
+		}
+	}
+
+	// After a confirmed cancel the library closes the delivery channel once
+	// the in-flight deliveries have been handed over.
+	requeued := 0
+	for msg := range msgs {
+		requeue(msg)
+		requeued++
+	}
+	if requeued > 0 {
+		log.WithField("requeued", requeued).Info("Requeued prefetched messages during shutdown")
+	}
+}
+
+// ackDelivery acknowledges a delivery and reports whether the broker took
+// the ack. A failed ack means the channel died underneath us; the message
+// will be redelivered, so callers must not record success side effects
+// (dedup, completion events) for it. The channel death itself closes the
+// delivery channel, which makes subscribe return an error and the worker
+// exit non-zero so the supervisor restarts it against a fresh connection.
+func ackDelivery(msg amqp.Delivery) bool {
+	if err := msg.Ack(false); err != nil {
+		ackFailures.WithLabelValues("ack").Inc()
+		log.WithError(err).WithField("messageId", msg.MessageId).Error("Ack failed, message will be redelivered")
+		return false
+	}
+	markConsumeConfirmed("first acked delivery")
+	return true
+}
+
+// nackDelivery is the Nack counterpart to ackDelivery.
+func nackDelivery(msg amqp.Delivery, requeue bool) bool {
+	if err := msg.Nack(false, requeue); err != nil {
+		ackFailures.WithLabelValues("nack").Inc()
+		log.WithError(err).WithField("messageId", msg.MessageId).Error("Nack failed, message will be redelivered")
+		return false
+	}
+	return true
+}
+
+// queueDwellFor returns how long a delivery sat on the queue before we
+// picked it up, or zero when the publisher did not set the AMQP timestamp
+// (it is optional, and absent timestamps decode to the zero time).
+func queueDwellFor(msg amqp.Delivery) time.Duration {
+	if msg.Timestamp.IsZero() {
+		return 0
+	}
+	return time.Since(msg.Timestamp)
+}
+
+// processDelivery runs handleMessage for one delivery and translates its
+// outcome into ack/nack and telemetry.
+func processDelivery(ctx context.Context, msg amqp.Delivery) {
+	// Every message gets its own Sentry hub so breadcrumbs and tags from
+	// concurrent messages stay separate.
+	ctx = sentry.SetHubOnContext(ctx, sentry.CurrentHub().Clone())
+	ctx = applyTraceContext(ctx, msg)
+	addBreadcrumb(ctx, "amqp", "message received", map[string]interface{}{
+		"messageId": msg.MessageId,
+	})
+
+	// Quorum queues count redeliveries for us; a message past the configured
+	// limit has failed transiently that many times already and is effectively
+	// poisonous — requeueing it again would just keep the loop going.
+	if cfg.MaxDeliveries > 0 {
+		if count := deliveryCount(msg); count >= int64(cfg.MaxDeliveries) {
+			redeliveriesDropped.Inc()
+			messagesProcessed.WithLabelValues("failed").Inc()
+			log.WithFields(log.Fields{
+				"messageId":     msg.MessageId,
+				"deliveryCount": count,
+				"limit":         cfg.MaxDeliveries,
+			}).Error("Delivery count limit reached, dropping message")
+			nackDelivery(msg, false)
+			return
+		}
+	}
+
+	if messageDedup != nil && messageDedup.lookup(dedupKey(msg)) {
+		messagesDeduped.Inc()
+		log.WithField("messageId", msg.MessageId).Info("Duplicate delivery within dedup window, acking without reprocessing")
+		ackDelivery(msg)
+		return
+	}
+
+	// At-most-once semantics ack before processing: a crash or failure from
+	// here on loses the message instead of redelivering it — that is the
+	// configured tradeoff, not an accident.
+	earlyAcked := false
+	if cfg.DeliverySemantics == semanticsAtMostOnce {
+		if !ackDelivery(msg) {
+			// The early ack never reached the broker, so the message will be
+			// redelivered anyway; processing it now could double-process,
+			// which is the one thing this mode promises not to do.
+			return
+		}
+		earlyAcked = true
+	}
+
+	// Publishers that set the AMQP Timestamp let us measure how long the
+	// message sat on the queue before processing started. Sustained high
+	// dwell means the worker is not keeping up with the publish rate.
+	dwell := queueDwellFor(msg)
+	if dwell > 0 {
+		queueDwell.Observe(dwell.Seconds())
+	}
+
+	result, err := handleMessage(ctx, msg)
+	observeWithExemplar(ctx, messageDuration, result.Duration.Seconds())
+	pagesFetched.Add(float64(result.PagesFetched))
+
+	if err == nil {
+		messagesProcessed.WithLabelValues("success").Inc()
+		fields := log.Fields{
+			"pages":    result.PagesFetched,
+			"upserted": result.CasesUpserted,
+			"skipped":  result.CasesSkipped,
+			"duration": result.Duration.String(),
+		}
+		if dwell > 0 {
+			fields["queueDwell"] = dwell.String()
+		}
+		log.WithFields(fields).WithFields(gcpLogFields(ctx)).Info("Message processed")
+		if !earlyAcked && !ackDelivery(msg) {
+			// The broker never saw the ack, so the message is coming back;
+			// remembering it or announcing completion now would make the
+			// redelivery look like a duplicate success.
+			return
+		}
+		if messageDedup != nil {
+			messageDedup.remember(dedupKey(msg))
+		}
+		publishCompletion(msg, result, nil)
+		return
+	}
+
+	if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+		// Shutdown, not an endpoint problem: requeue quietly instead of
+		// polluting Sentry with cancellation noise. The ctx.Err check also
+		// catches failures that merely coincided with shutdown — when in
+		// doubt, another replica reprocessing the message beats losing it.
+		if earlyAcked {
+			messagesProcessed.WithLabelValues("failed").Inc()
+			log.WithError(err).Warn("Processing interrupted after early ack, message lost (at-most-once)")
+			return
+		}
+		messagesProcessed.WithLabelValues("requeued").Inc()
+		log.WithError(err).Info("Processing interrupted by shutdown, requeueing message")
+		nackDelivery(msg, true)
+		return
+	}
+
+	captureException(ctx, err)
+	if isTransient(err) {
+		recentErrors.add(msg.MessageId, "transient", err)
+		if earlyAcked {
+			messagesProcessed.WithLabelValues("failed").Inc()
+			log.WithError(err).Warn("Transient failure after early ack, message lost (at-most-once)")
+			return
+		}
+		messagesProcessed.WithLabelValues("requeued").Inc()
+		log.WithError(err).WithFields(gcpLogFields(ctx)).Warn("Transient failure, requeueing message")
+		nackDelivery(msg, true)
+		return
+	}
+
+	recentErrors.add(msg.MessageId, "permanent", err)
+	messagesProcessed.WithLabelValues("failed").Inc()
+	log.WithError(err).WithFields(gcpLogFields(ctx)).Error("Permanent failure, dropping message")
+	if !earlyAcked && !nackDelivery(msg, false) {
+		// The drop never reached the broker; the redelivery will fail and
+		// drop again, so the completion event waits for that attempt.
+		return
+	}
+	publishCompletion(msg, result, err)
+}
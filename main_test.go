@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// fakeAcknowledger records ack/nack outcomes for processDelivery tests.
+// ackErr makes every Ack fail, simulating a channel that died before the
+// acknowledgement went out.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acks    int
+	nacks   int
+	requeue bool
+	ackErr  error
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ackErr != nil {
+		return f.ackErr
+	}
+	f.acks++
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nacks++
+	f.requeue = requeue
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+// fakeCanceler stands in for the AMQP channel in drain tests; cancelling
+// closes the delivery channel the way the real library does.
+type fakeCanceler struct {
+	cancelled bool
+	closeCh   func()
+}
+
+func (f *fakeCanceler) Cancel(consumer string, noWait bool) error {
+	f.cancelled = true
+	f.closeCh()
+	return nil
+}
+
+func TestDrainRequeuesPrefetchedMessages(t *testing.T) {
+	// Prefetch of five: one message is being processed, four sit buffered
+	// when shutdown hits. All four must go back to the queue.
+	msgs := make(chan amqp.Delivery, 5)
+	ack := &fakeAcknowledger{}
+	for i := 0; i < 4; i++ {
+		msgs <- amqp.Delivery{Acknowledger: ack}
+	}
+	canceler := &fakeCanceler{closeCh: func() { close(msgs) }}
+
+	drainDeliveries(canceler, "test-tag", msgs)
+
+	if !canceler.cancelled {
+		t.Error("consumer was not cancelled")
+	}
+	if ack.nacks != 4 {
+		t.Errorf("%d buffered messages were nacked, want 4", ack.nacks)
+	}
+	if !ack.requeue {
+		t.Error("buffered messages must be requeued, not dropped")
+	}
+	if ack.acks != 0 {
+		t.Errorf("buffered messages were acked %d times, want 0", ack.acks)
+	}
+}
+
+func TestShutdownMidProcessingRequeuesMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fb := newFakeBackends(t, 3, 5)
+	// The first page succeeds but cancels the worker context, simulating a
+	// SIGTERM arriving mid-message; the second fetch then fails.
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NumberOfPages: 3, FraudCases: make([]FraudCases, 5)})
+	})
+
+	ack := &fakeAcknowledger{}
+	processDelivery(ctx, amqp.Delivery{Acknowledger: ack, Body: []byte("test-key")})
+
+	if ack.acks != 0 {
+		t.Errorf("message was acked %d times, want 0", ack.acks)
+	}
+	if ack.nacks != 1 {
+		t.Fatalf("message was nacked %d times, want 1", ack.nacks)
+	}
+	if !ack.requeue {
+		t.Error("shutdown nack must requeue the message, not drop it")
+	}
+}
+
+func TestFailedAckSkipsSuccessSideEffects(t *testing.T) {
+	// The sync succeeds but the channel dies before the ack lands. The
+	// message will be redelivered, so it must not enter the dedup cache —
+	// otherwise the redelivery would be swallowed as a duplicate.
+	newFakeBackends(t, 1, 5)
+	cfg.DedupTTL = time.Minute
+	initDedup(cfg)
+
+	ack := &fakeAcknowledger{ackErr: errors.New("channel/connection is not open")}
+	msg := amqp.Delivery{Acknowledger: ack, Body: []byte("test-key"), MessageId: "msg-1"}
+	processDelivery(context.Background(), msg)
+
+	if ack.acks != 0 {
+		t.Errorf("ack count = %d, want 0 (Ack returned an error)", ack.acks)
+	}
+	if messageDedup.lookup(dedupKey(msg)) {
+		t.Error("message entered the dedup cache despite the failed ack")
+	}
+}
+
+func TestPermanentFailureDropsMessage(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	ack := &fakeAcknowledger{}
+	processDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("test-key")})
+
+	if ack.nacks != 1 {
+		t.Fatalf("message was nacked %d times, want 1", ack.nacks)
+	}
+	if ack.requeue {
+		t.Error("permanent failure must not requeue the message")
+	}
+}
+
+func TestDeliveryCountLimitDropsMessage(t *testing.T) {
+	newFakeBackends(t, 1, 5)
+	cfg.MaxDeliveries = 3
+
+	ack := &fakeAcknowledger{}
+	msg := amqp.Delivery{
+		Acknowledger: ack,
+		Body:         []byte("test-key"),
+		Headers:      amqp.Table{"x-delivery-count": int64(3)},
+	}
+	processDelivery(context.Background(), msg)
+
+	if ack.acks != 0 {
+		t.Errorf("ack count = %d, want 0", ack.acks)
+	}
+	if ack.nacks != 1 {
+		t.Fatalf("nack count = %d, want 1", ack.nacks)
+	}
+	if ack.requeue {
+		t.Error("over-limit message must be dropped, not requeued")
+	}
+}
+
+func TestQuorumQueueArgs(t *testing.T) {
+	cfg = loadConfig()
+	cfg.QueueType = queueTypeQuorum
+	args := queueArgs()
+	if got := args["x-queue-type"]; got != queueTypeQuorum {
+		t.Errorf("x-queue-type = %v, want %q", got, queueTypeQuorum)
+	}
+
+	cfg.QueueType = queueTypeClassic
+	if args := queueArgs(); args != nil {
+		t.Errorf("classic queue without single-active-consumer should declare no args, got %v", args)
+	}
+}
+
+func TestLogFormatterSelection(t *testing.T) {
+	if _, ok := logFormatter(logFormatJSON).(*log.JSONFormatter); !ok {
+		t.Error("json format should select the JSON formatter")
+	}
+	tf, ok := logFormatter(logFormatLogfmt).(*log.TextFormatter)
+	if !ok || !tf.DisableColors {
+		t.Error("logfmt format should select the text formatter with colors off")
+	}
+	if _, ok := logFormatter(logFormatText).(*log.TextFormatter); !ok {
+		t.Error("text format should select the text formatter")
+	}
+}
+
+func TestAtMostOnceAcksBeforeProcessing(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+	cfg.DeliverySemantics = semanticsAtMostOnce
+
+	ack := &fakeAcknowledger{}
+	var acksAtFetch int
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ack.mu.Lock()
+		acksAtFetch = ack.acks
+		ack.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FraudCasesResponse{NumberOfPages: 1, FraudCases: make([]FraudCases, 5)})
+	})
+
+	processDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("test-key")})
+
+	if acksAtFetch != 1 {
+		t.Errorf("acks before first fetch = %d, want 1 (ack must precede processing)", acksAtFetch)
+	}
+	if ack.acks != 1 {
+		t.Errorf("total acks = %d, want exactly 1", ack.acks)
+	}
+	if ack.nacks != 0 {
+		t.Errorf("nacks = %d, want 0", ack.nacks)
+	}
+}
+
+func TestAtMostOnceNeverRequeuesFailures(t *testing.T) {
+	fb := newFakeBackends(t, 1, 5)
+	cfg.DeliverySemantics = semanticsAtMostOnce
+	fb.cdq.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	ack := &fakeAcknowledger{}
+	processDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("test-key")})
+
+	if ack.acks != 1 {
+		t.Errorf("acks = %d, want 1 (early ack)", ack.acks)
+	}
+	if ack.nacks != 0 {
+		t.Errorf("nacks = %d, want 0 (already acked, nothing to requeue)", ack.nacks)
+	}
+}
+
+func TestQueueDwellForHandlesAbsentTimestamp(t *testing.T) {
+	if got := queueDwellFor(amqp.Delivery{}); got != 0 {
+		t.Errorf("queueDwellFor without timestamp = %v, want 0", got)
+	}
+
+	msg := amqp.Delivery{Timestamp: time.Now().Add(-2 * time.Minute)}
+	got := queueDwellFor(msg)
+	if got < 2*time.Minute || got > 2*time.Minute+time.Second {
+		t.Errorf("queueDwellFor = %v, want ~2m", got)
+	}
+}
+
+func TestEnvOrUnknownDefaults(t *testing.T) {
+	t.Setenv("DEPLOY_REGION", "")
+	if got := envOrUnknown("DEPLOY_REGION"); got != "unknown" {
+		t.Errorf("unset region = %q, want \"unknown\"", got)
+	}
+	t.Setenv("DEPLOY_REGION", "eu-west-1")
+	if got := envOrUnknown("DEPLOY_REGION"); got != "eu-west-1" {
+		t.Errorf("region = %q, want \"eu-west-1\"", got)
+	}
+}
+
+func TestDeployFieldsHookStampsEntries(t *testing.T) {
+	entry := &log.Entry{Data: log.Fields{}}
+	if err := (deployFieldsHook{}).Fire(entry); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	if entry.Data["region"] == nil || entry.Data["zone"] == nil {
+		t.Errorf("entry data = %v, want region and zone fields", entry.Data)
+	}
+}
+
+func TestAckDeliveryConfirmsConsumeCapability(t *testing.T) {
+	cfg = loadConfig()
+	cfg.ReadinessRequireFirstMessage = true
+	firstConsumeConfirmed.Store(false)
+	defer firstConsumeConfirmed.Store(false)
+
+	ackDelivery(amqp.Delivery{Acknowledger: &fakeAcknowledger{}})
+	if !firstConsumeConfirmed.Load() {
+		t.Error("a successful ack did not confirm consume capability")
+	}
+}
+
+func TestFailedAckDoesNotConfirmConsumeCapability(t *testing.T) {
+	cfg = loadConfig()
+	cfg.ReadinessRequireFirstMessage = true
+	firstConsumeConfirmed.Store(false)
+	defer firstConsumeConfirmed.Store(false)
+
+	ackDelivery(amqp.Delivery{Acknowledger: &fakeAcknowledger{ackErr: errors.New("channel closed")}})
+	if firstConsumeConfirmed.Load() {
+		t.Error("a failed ack must not confirm consume capability")
+	}
+}
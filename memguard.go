@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// memoryGuard watches heap usage and throttles the worker to serial
+// processing while usage sits above the configured share of the memory
+// limit. It trades throughput for staying alive: an unexpectedly large
+// tenant slows the pod down instead of OOM-killing it. Opt-in via
+// MEMORY_GUARD_ENABLED.
+type memoryGuard struct {
+	limitBytes uint64
+	threshold  float64
+
+	// heapBytes is swappable so tests can simulate pressure without
+	// actually allocating.
+	heapBytes func() uint64
+
+	mu        sync.Mutex
+	throttled bool
+
+	// serial is the single slot every message shares while throttled.
+	serial chan struct{}
+}
+
+var memGuard *memoryGuard
+
+func initMemoryGuard(cfg *Config) {
+	if !cfg.MemoryGuardEnabled {
+		memGuard = nil
+		return
+	}
+	limit := uint64(cfg.MemoryGuardLimitMB) * 1024 * 1024
+	if limit == 0 {
+		limit = cgroupMemoryLimit()
+	}
+	if limit == 0 {
+		log.Warn("Memory guard enabled but no limit found (set MEMORY_GUARD_LIMIT_MB or run with a cgroup limit), disabling")
+		memGuard = nil
+		return
+	}
+	memGuard = &memoryGuard{
+		limitBytes: limit,
+		threshold:  cfg.MemoryGuardThresholdPct / 100,
+		heapBytes: func() uint64 {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			return ms.HeapAlloc
+		},
+		serial: make(chan struct{}, 1),
+	}
+}
+
+// cgroupMemoryLimit reads the pod's memory limit from cgroup v2, falling
+// back to v1. Returns zero when no limit applies.
+func cgroupMemoryLimit() uint64 {
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(string(raw))
+		if v == "max" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			continue
+		}
+		return n
+	}
+	return 0
+}
+
+// check samples heap usage and flips the throttle on transitions, logging
+// each direction once instead of every tick.
+func (g *memoryGuard) check() {
+	heap := g.heapBytes()
+	over := float64(heap) > float64(g.limitBytes)*g.threshold
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if over == g.throttled {
+		return
+	}
+	g.throttled = over
+	fields := log.Fields{
+		"heapBytes":  heap,
+		"limitBytes": g.limitBytes,
+	}
+	if over {
+		memoryThrottled.Set(1)
+		log.WithFields(fields).Warn("Memory pressure high, throttling to serial processing")
+	} else {
+		memoryThrottled.Set(0)
+		log.WithFields(fields).Info("Memory pressure eased, restoring concurrency")
+	}
+}
+
+func (g *memoryGuard) isThrottled() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.throttled
+}
+
+// limitSlot serializes the caller while the guard is throttled, returning a
+// release func, or nil when running unthrottled. Checked per message so
+// in-flight work finishes normally and new work queues behind the slot.
+func (g *memoryGuard) limitSlot(ctx context.Context) func() {
+	if !g.isThrottled() {
+		return nil
+	}
+	select {
+	case g.serial <- struct{}{}:
+		return func() { <-g.serial }
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// startMemoryGuard samples heap usage on an interval until the context is
+// cancelled. A no-op when the guard is disabled.
+func startMemoryGuard(ctx context.Context) {
+	if memGuard == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.MemoryGuardInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				memGuard.check()
+			}
+		}
+	}()
+}
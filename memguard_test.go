@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryGuardThrottlesAndRecovers(t *testing.T) {
+	heap := uint64(0)
+	g := &memoryGuard{
+		limitBytes: 1000,
+		threshold:  0.8,
+		heapBytes:  func() uint64 { return heap },
+		serial:     make(chan struct{}, 1),
+	}
+
+	heap = 700
+	g.check()
+	if g.isThrottled() {
+		t.Error("guard throttled below the threshold")
+	}
+
+	heap = 900
+	g.check()
+	if !g.isThrottled() {
+		t.Error("guard did not throttle above the threshold")
+	}
+	release := g.limitSlot(context.Background())
+	if release == nil {
+		t.Fatal("expected a serial slot while throttled")
+	}
+	release()
+
+	heap = 500
+	g.check()
+	if g.isThrottled() {
+		t.Error("guard did not recover after pressure eased")
+	}
+	if g.limitSlot(context.Background()) != nil {
+		t.Error("unthrottled guard must not hand out serial slots")
+	}
+}
+
+func TestNilMemoryGuardIsInert(t *testing.T) {
+	var g *memoryGuard
+	if g.isThrottled() {
+		t.Error("nil guard reported throttled")
+	}
+	if g.limitSlot(context.Background()) != nil {
+		t.Error("nil guard handed out a slot")
+	}
+}
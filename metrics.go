@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// deployRegion and deployZone identify where this replica runs in a
+// multi-region fleet. They are read straight from the environment at package
+// init — before loadConfig — because the metric vars below bake them in as
+// constant labels.
+var (
+	deployRegion = envOrUnknown("DEPLOY_REGION")
+	deployZone   = envOrUnknown("DEPLOY_ZONE")
+)
+
+func envOrUnknown(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return "unknown"
+}
+
+// metricsRegisterer stamps every metric the worker registers with the
+// region/zone labels so dashboards can slice any series by location.
+var metricsRegisterer = prometheus.WrapRegistererWith(
+	prometheus.Labels{"region": deployRegion, "zone": deployZone},
+	prometheus.DefaultRegisterer,
+)
+
+var (
+	messagesProcessed = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_messages_processed_total",
+		Help: "Messages consumed, labeled by outcome.",
+	}, []string{"status"})
+
+	messagesDeduped = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_messages_deduped_total",
+		Help: "Duplicate deliveries acknowledged without reprocessing.",
+	})
+
+	messagesCoalesced = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_messages_coalesced_total",
+		Help: "Messages collapsed into an already-pending sync for the same key.",
+	})
+
+	redeliveriesDropped = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_redeliveries_dropped_total",
+		Help: "Messages dropped for exceeding RMQ_MAX_DELIVERIES (quorum queues).",
+	})
+
+	ackFailures = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_ack_failures_total",
+		Help: "Ack/Nack calls that failed, labeled by operation.",
+	}, []string{"op"})
+
+	auditDropped = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_audit_entries_dropped_total",
+		Help: "Audit log entries dropped because the write buffer was full.",
+	})
+
+	failedCasesDropped = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_failed_case_records_dropped_total",
+		Help: "Failed-case records dropped because the write buffer was full.",
+	})
+
+	workerPaused = promauto.With(metricsRegisterer).NewGauge(prometheus.GaugeOpts{
+		Name: "fraud_worker_paused",
+		Help: "Whether consumption is paused via the admin endpoint (1) or running (0).",
+	})
+
+	memoryThrottled = promauto.With(metricsRegisterer).NewGauge(prometheus.GaugeOpts{
+		Name: "fraud_worker_memory_throttled",
+		Help: "Whether the memory guard is currently throttling (1) or not (0).",
+	})
+
+	queueDepth = promauto.With(metricsRegisterer).NewGauge(prometheus.GaugeOpts{
+		Name: "fraud_worker_queue_depth",
+		Help: "Messages waiting in the consumed queue at the last inspection.",
+	})
+
+	queueBacklogged = promauto.With(metricsRegisterer).NewGauge(prometheus.GaugeOpts{
+		Name: "fraud_worker_queue_backlogged",
+		Help: "Whether queue depth exceeds QUEUE_DEPTH_HIGH_WATERMARK (1) or not (0).",
+	})
+
+	consumerIdle = promauto.With(metricsRegisterer).NewHistogram(prometheus.HistogramOpts{
+		Name:    "fraud_worker_consumer_idle_seconds",
+		Help:    "Time spent blocked waiting for the next delivery. High values mean over-provisioned, near-zero means saturated.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+
+	queueDwell = promauto.With(metricsRegisterer).NewHistogram(prometheus.HistogramOpts{
+		Name:    "fraud_worker_queue_dwell_seconds",
+		Help:    "Time from publish (AMQP Timestamp) to processing start. Only observed for messages whose publisher set the timestamp.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+	})
+
+	messageDuration = promauto.With(metricsRegisterer).NewHistogram(prometheus.HistogramOpts{
+		Name:    "fraud_worker_message_duration_seconds",
+		Help:    "End-to-end processing time per message.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	httpRequestDuration = promauto.With(metricsRegisterer).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fraud_worker_http_request_duration_seconds",
+		Help:    "Duration of outbound API calls, labeled by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	httpResponseBuckets = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_http_responses_total",
+		Help: "HTTP responses per endpoint, labeled by status bucket (2xx..5xx, 429).",
+	}, []string{"endpoint", "bucket"})
+
+	fleetLimiterFallbacks = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_fleet_limiter_fallbacks_total",
+		Help: "Token acquisitions that fell back to the local limiter because the fleet limiter was unavailable.",
+	})
+
+	retriesTotal = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_retries_total",
+		Help: "Retry attempts, labeled by endpoint and reason.",
+	}, []string{"endpoint", "reason"})
+
+	retryExhausted = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_retry_exhausted_total",
+		Help: "Requests that failed after using their whole retry budget.",
+	}, []string{"endpoint"})
+
+	requestTimeouts = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_request_timeouts_total",
+		Help: "Outbound requests that hit their deadline, labeled by endpoint.",
+	}, []string{"endpoint"})
+
+	requestCancellations = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_request_cancellations_total",
+		Help: "Outbound requests aborted by context cancellation (shutdown).",
+	}, []string{"endpoint"})
+
+	circuitState = promauto.With(metricsRegisterer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fraud_worker_circuit_state",
+		Help: "Circuit breaker state per endpoint: 0 closed, 1 open, 2 half-open.",
+	}, []string{"endpoint"})
+
+	cdqPageCaseCount = promauto.With(metricsRegisterer).NewHistogram(prometheus.HistogramOpts{
+		Name:    "fraud_worker_cdq_page_case_count",
+		Help:    "Fraud cases per fetched CDQ page.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	cdqPageResponseBytes = promauto.With(metricsRegisterer).NewHistogram(prometheus.HistogramOpts{
+		Name:    "fraud_worker_cdq_page_response_bytes",
+		Help:    "Body size per fetched CDQ page.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 10),
+	})
+
+	catenaxFailovers = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_catenax_failovers_total",
+		Help: "Catenax requests retried against the fallback endpoint.",
+	})
+
+	futureCutoffsRejected = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_future_cutoffs_rejected_total",
+		Help: "Deletes skipped because the server-provided cutoff was implausibly far in the future.",
+	})
+
+	upsertCountMismatches = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_upsert_count_mismatches_total",
+		Help: "Upsert responses whose accepted count differed from the cases sent.",
+	})
+
+	digestMismatches = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_digest_mismatches_total",
+		Help: "Upsert responses whose echoed Digest header did not match the sent body.",
+	})
+
+	catenaxInFlightWrites = promauto.With(metricsRegisterer).NewGauge(prometheus.GaugeOpts{
+		Name: "fraud_worker_catenax_inflight_writes",
+		Help: "Catenax write requests currently in flight.",
+	})
+
+	apiBudgetExceeded = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_api_budget_exceeded_total",
+		Help: "Messages aborted for exceeding MAX_API_CALLS_PER_MESSAGE.",
+	})
+
+	certExpirySeconds = promauto.With(metricsRegisterer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fraud_worker_cert_expiry_seconds",
+		Help: "Seconds until the endpoint's TLS certificate expires, per endpoint.",
+	}, []string{"endpoint"})
+
+	dailyBudgetSpent = promauto.With(metricsRegisterer).NewGauge(prometheus.GaugeOpts{
+		Name: "fraud_worker_daily_budget_spent",
+		Help: "CDQ calls made in the current daily budget window.",
+	})
+
+	dailyBudgetPauses = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_daily_budget_pauses_total",
+		Help: "Times consumption was paused because the daily CDQ call budget ran out.",
+	})
+
+	cdqPageWarnings = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_cdq_page_warnings_total",
+		Help: "Page-count anomalies detected, labeled by check (threshold, delta).",
+	}, []string{"check"})
+
+	cdqPagesSkipped = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_cdq_pages_skipped_total",
+		Help: "CDQ pages abandoned after retries in best-effort mode.",
+	})
+
+	unchangedCasesSkipped = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_unchanged_cases_skipped_total",
+		Help: "Cases skipped because their fingerprint matched the previous sync.",
+	})
+
+	inactiveCasesFiltered = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_inactive_cases_filtered_total",
+		Help: "Soft-deleted CDQ cases dropped before upsert (CDQ_SKIP_INACTIVE).",
+	})
+
+	pagesFetched = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_pages_fetched_total",
+		Help: "CDQ pages fetched across all messages.",
+	})
+
+	fraudCasesUpserted = promauto.With(metricsRegisterer).NewCounter(prometheus.CounterOpts{
+		Name: "fraud_worker_fraud_cases_upserted_total",
+		Help: "Fraud cases successfully written to Catenax.",
+	})
+
+	fraudCasesByType = promauto.With(metricsRegisterer).NewCounterVec(prometheus.CounterOpts{
+		Name: "fraud_worker_fraud_cases_by_type_total",
+		Help: "Upserted fraud cases by case type; non-allowlisted types count as \"other\".",
+	}, []string{"type"})
+)
+
+// recordCaseTypes counts upserted cases by fraud-case Type when
+// ENABLE_TYPE_METRICS is on. Only allowlisted types become label values —
+// Type is free-form upstream, and unbounded label cardinality would blow up
+// the time-series store — everything else lands in the "other" bucket.
+func recordCaseTypes(cases []FraudCases) {
+	if !cfg.EnableTypeMetrics {
+		return
+	}
+	for _, fc := range cases {
+		label := "other"
+		if cfg.CaseTypeAllowlist[fc.Type] {
+			label = fc.Type
+		}
+		fraudCasesByType.WithLabelValues(label).Inc()
+	}
+}
+
+// observeRequest records the latency histogram for an outbound call and, when
+// a slow threshold is configured and exceeded, emits a grep-able warning so
+// creeping latency is visible without a dashboard. The context supplies the
+// optional trace id for exemplars.
+func observeRequest(ctx context.Context, endpoint, rawURL string, start time.Time, slowThreshold time.Duration) {
+	duration := time.Since(start)
+	observeWithExemplar(ctx, httpRequestDuration.WithLabelValues(endpoint), duration.Seconds())
+	if slowThreshold > 0 && duration > slowThreshold {
+		log.WithFields(log.Fields{
+			"endpoint":  endpoint,
+			"url":       redactURL(rawURL),
+			"duration":  duration.String(),
+			"threshold": slowThreshold.String(),
+		}).Warn("Slow request")
+	}
+}
+
+// recordStatus counts one HTTP response in its status bucket. Call it right
+// after every successful httpClient.Do so 4xx/5xx/429 rates line up across
+// endpoints.
+func recordStatus(endpoint string, status int) {
+	httpResponseBuckets.WithLabelValues(endpoint, statusBucket(status)).Inc()
+}
+
+// redactURL strips query parameters and userinfo so logged URLs never leak
+// credentials or tenant-identifying parameters.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<unparseable>"
+	}
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordCaseTypesBucketsUnknownTypes(t *testing.T) {
+	cfg = loadConfig()
+	cfg.EnableTypeMetrics = true
+	cfg.CaseTypeAllowlist = parseTypeAllowlist("PHISHING, FAKE_SHOP")
+
+	phishingBefore := testutil.ToFloat64(fraudCasesByType.WithLabelValues("PHISHING"))
+	otherBefore := testutil.ToFloat64(fraudCasesByType.WithLabelValues("other"))
+
+	recordCaseTypes([]FraudCases{
+		{CdlId: "a", Type: "PHISHING"},
+		{CdlId: "b", Type: "PHISHING"},
+		{CdlId: "c", Type: "FAKE_SHOP"},
+		{CdlId: "d", Type: "SOMETHING_NEW"},
+		{CdlId: "e", Type: ""},
+	})
+
+	if got := testutil.ToFloat64(fraudCasesByType.WithLabelValues("PHISHING")) - phishingBefore; got != 2 {
+		t.Errorf("PHISHING count = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(fraudCasesByType.WithLabelValues("other")) - otherBefore; got != 2 {
+		t.Errorf("other bucket = %v, want 2 (unknown and empty types)", got)
+	}
+
+	// Disabled, the counter must not move at all.
+	cfg.EnableTypeMetrics = false
+	recordCaseTypes([]FraudCases{{CdlId: "f", Type: "PHISHING"}})
+	if got := testutil.ToFloat64(fraudCasesByType.WithLabelValues("PHISHING")) - phishingBefore; got != 2 {
+		t.Errorf("PHISHING count after disabling = %v, want unchanged 2", got)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// catenaxToken holds the bearer token for Catenax calls when OAuth is
+// enabled (OAUTH_TOKEN_URL set); nil otherwise.
+var catenaxToken *tokenSource
+
+type tokenSource struct {
+	mu     sync.RWMutex
+	token  string
+	expiry time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// initOAuth fetches the first token synchronously so bad credentials fail
+// fast at startup instead of on the first upsert, then keeps it fresh in the
+// background. This also avoids a burst of token requests when concurrent
+// batches all hit a cold cache.
+func initOAuth(ctx context.Context, cfg *Config) error {
+	if cfg.OAuthTokenURL == "" {
+		return nil
+	}
+	ts := &tokenSource{}
+	if err := ts.refresh(ctx); err != nil {
+		return err
+	}
+	catenaxToken = ts
+	go ts.refreshLoop(ctx)
+	return nil
+}
+
+// refresh performs one client-credentials grant and swaps in the new token.
+func (ts *tokenSource) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
+	defer cancel()
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {cfg.OAuthClientID},
+		"client_secret": {cfg.OAuthClientSecret},
+	}
+	if cfg.OAuthScope != "" {
+		form.Set("scope", cfg.OAuthScope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.OAuthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return wrapRequestError("oauth_token", "OAuth token request", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return transient("network", "reading OAuth token response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OAuth token endpoint returned status %d: %s", resp.StatusCode, truncateBody(body, 256))
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("unmarshaling OAuth token response failed: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("OAuth token response contained no access_token")
+	}
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = time.Hour
+	}
+
+	ts.mu.Lock()
+	ts.token = token.AccessToken
+	ts.expiry = time.Now().Add(lifetime)
+	ts.mu.Unlock()
+	log.WithField("expiresIn", lifetime.String()).Debug("OAuth token refreshed")
+	return nil
+}
+
+// refreshLoop proactively renews the token at 80% of its lifetime so a
+// message never pays the token round-trip or races an expiring token.
+func (ts *tokenSource) refreshLoop(ctx context.Context) {
+	for {
+		ts.mu.RLock()
+		remaining := time.Until(ts.expiry)
+		ts.mu.RUnlock()
+
+		wait := remaining * 8 / 10
+		if wait < 30*time.Second {
+			wait = 30 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if err := ts.refresh(ctx); err != nil {
+			log.WithError(err).Error("OAuth token refresh failed, will retry")
+			captureException(ctx, err)
+		}
+	}
+}
+
+func (ts *tokenSource) current() string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.token
+}
+
+// authorizeRequest attaches the bearer token to an outbound Catenax request
+// when OAuth is enabled.
+func authorizeRequest(req *http.Request) {
+	if catenaxToken != nil {
+		req.Header.Set("Authorization", "Bearer "+catenaxToken.current())
+	}
+}
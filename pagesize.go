@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pageSizer is a per-message AIMD controller for the CDQ page size: grow
+// additively while fetches come back fast, halve on slow fetches or transient
+// failures. State lives for one message only, so heterogeneous tenants each
+// converge on their own sweet spot without any persisted tuning. Concurrent
+// page fetchers share one controller, so size is mutex-guarded.
+type pageSizer struct {
+	min, max int
+	slow     time.Duration
+
+	mu   sync.Mutex
+	size int
+}
+
+// newPageSizer returns nil unless CDQ_ADAPTIVE_PAGE_SIZE is enabled.
+func newPageSizer(cfg *Config) *pageSizer {
+	if !cfg.CdqAdaptivePageSize {
+		return nil
+	}
+	return &pageSizer{
+		min:  cfg.CdqPageSizeMin,
+		max:  cfg.CdqPageSizeMax,
+		slow: cfg.CdqAdaptiveSlow,
+		size: cfg.CdqPageSizeMin,
+	}
+}
+
+func (p *pageSizer) current() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// observe feeds one fetch outcome into the controller.
+func (p *pageSizer) observe(duration time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil && !isTransient(err):
+		// Permanent errors say nothing about capacity; leave the size alone.
+	case err != nil || duration > p.slow:
+		p.size /= 2
+		if p.size < p.min {
+			p.size = p.min
+		}
+	default:
+		p.size += p.min
+		if p.size > p.max {
+			p.size = p.max
+		}
+	}
+}
+
+type pageSizerKey struct{}
+
+func withPageSizer(ctx context.Context, p *pageSizer) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pageSizerKey{}, p)
+}
+
+func pageSizerFrom(ctx context.Context) *pageSizer {
+	p, _ := ctx.Value(pageSizerKey{}).(*pageSizer)
+	return p
+}
+
+// effectivePageSize is the page size for one fetch: the adaptive controller's
+// current value when enabled, the static configuration otherwise.
+func effectivePageSize(ctx context.Context) int {
+	if p := pageSizerFrom(ctx); p != nil {
+		return p.current()
+	}
+	return pageSize()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPageSizerGrowsAdditivelyAndShrinksMultiplicatively(t *testing.T) {
+	p := &pageSizer{min: 100, max: 400, slow: time.Second, size: 100}
+
+	p.observe(10*time.Millisecond, nil)
+	if p.current() != 200 {
+		t.Errorf("size after fast fetch = %d, want 200", p.current())
+	}
+	p.observe(10*time.Millisecond, nil)
+	p.observe(10*time.Millisecond, nil)
+	if p.current() != 400 {
+		t.Errorf("size is capped at max, got %d, want 400", p.current())
+	}
+	p.observe(2*time.Second, nil)
+	if p.current() != 200 {
+		t.Errorf("size after slow fetch = %d, want 200", p.current())
+	}
+	p.observe(10*time.Millisecond, transient("http_5xx", "boom"))
+	if p.current() != 100 {
+		t.Errorf("size after transient failure = %d, want 100", p.current())
+	}
+	p.observe(10*time.Millisecond, transient("http_5xx", "boom"))
+	if p.current() != 100 {
+		t.Errorf("size never drops below min, got %d, want 100", p.current())
+	}
+}
+
+func TestPageSizerIgnoresPermanentErrors(t *testing.T) {
+	p := &pageSizer{min: 100, max: 400, slow: time.Second, size: 200}
+	p.observe(10*time.Millisecond, fmt.Errorf("bad request"))
+	if p.current() != 200 {
+		t.Errorf("size after permanent error = %d, want 200", p.current())
+	}
+}
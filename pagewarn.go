@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pageCountTracker remembers the page count of each tenant's previous sync
+// so a sudden jump can be flagged. In-memory only: after a restart the first
+// sync just re-seeds the baseline.
+type pageCountTracker struct {
+	mu   sync.Mutex
+	last map[string]int
+}
+
+var pageCounts = &pageCountTracker{last: make(map[string]int)}
+
+// warnOnPageAnomalies flags suspicious page counts: above the absolute
+// CDQ_PAGE_WARN_THRESHOLD, or moved by more than CDQ_PAGE_WARN_DELTA_PCT
+// percent since the tenant's previous sync. A jump usually means an upstream
+// data anomaly, and catching it here is cheaper than the quota and latency
+// problems it causes later. Both checks are off at their zero defaults.
+func warnOnPageAnomalies(tenant string, numberOfPages int) {
+	if threshold := cfg.CdqPageWarnThreshold; threshold > 0 && numberOfPages > threshold {
+		cdqPageWarnings.WithLabelValues("threshold").Inc()
+		log.WithFields(log.Fields{
+			"tenant":        tenant,
+			"numberOfPages": numberOfPages,
+			"threshold":     threshold,
+		}).Warn("Page count exceeds warning threshold")
+	}
+
+	pageCounts.mu.Lock()
+	prev, seen := pageCounts.last[tenant]
+	pageCounts.last[tenant] = numberOfPages
+	pageCounts.mu.Unlock()
+
+	if pct := cfg.CdqPageWarnDeltaPct; pct > 0 && seen && prev > 0 {
+		change := float64(numberOfPages-prev) / float64(prev) * 100
+		if change < 0 {
+			change = -change
+		}
+		if change > pct {
+			cdqPageWarnings.WithLabelValues("delta").Inc()
+			log.WithFields(log.Fields{
+				"tenant":        tenant,
+				"numberOfPages": numberOfPages,
+				"previousPages": prev,
+				"changePct":     change,
+			}).Warn("Page count changed sharply since the previous sync")
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWarnOnPageAnomaliesThreshold(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqPageWarnThreshold = 100
+
+	before := testutil.ToFloat64(cdqPageWarnings.WithLabelValues("threshold"))
+	warnOnPageAnomalies("tenant-threshold", 100) // at the threshold, fine
+	warnOnPageAnomalies("tenant-threshold", 101) // above, warns
+	got := testutil.ToFloat64(cdqPageWarnings.WithLabelValues("threshold")) - before
+	if got != 1 {
+		t.Errorf("threshold warnings = %v, want 1", got)
+	}
+}
+
+func TestWarnOnPageAnomaliesDelta(t *testing.T) {
+	cfg = loadConfig()
+	cfg.CdqPageWarnDeltaPct = 50
+
+	before := testutil.ToFloat64(cdqPageWarnings.WithLabelValues("delta"))
+	warnOnPageAnomalies("tenant-delta", 10) // first sync seeds the baseline
+	warnOnPageAnomalies("tenant-delta", 12) // +20%, within tolerance
+	warnOnPageAnomalies("tenant-delta", 30) // +150%, warns
+	warnOnPageAnomalies("tenant-delta", 5)  // -83%, shrinking warns too
+	got := testutil.ToFloat64(cdqPageWarnings.WithLabelValues("delta")) - before
+	if got != 2 {
+		t.Errorf("delta warnings = %v, want 2", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pauseGate lets the worker quiesce without killing the pod: while paused
+// the consume loop stops taking deliveries, so messages stay safely on the
+// broker. Driven by the authenticated /pause and /resume admin endpoints and
+// by the daily CDQ budget (see dailybudget.go), which records why in the
+// reason field.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{}
+}
+
+var pauser = &pauseGate{}
+
+func (g *pauseGate) pause(reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	workerPaused.Set(1)
+	log.WithField("reason", reason).Warn("Consumption paused")
+}
+
+func (g *pauseGate) resume(reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return
+	}
+	g.paused = false
+	close(g.resumed)
+	workerPaused.Set(0)
+	log.WithField("reason", reason).Info("Consumption resumed")
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait blocks while paused, returning early if the context is cancelled so
+// shutdown still works from the paused state.
+func (g *pauseGate) wait(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		if !g.paused {
+			g.mu.Unlock()
+			return nil
+		}
+		resumed := g.resumed
+		g.mu.Unlock()
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPauseGateBlocksUntilResume(t *testing.T) {
+	g := &pauseGate{}
+	g.pause("test")
+	if !g.isPaused() {
+		t.Fatal("gate not paused after pause()")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- g.wait(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("wait returned while still paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	g.resume("test")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("wait after resume: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after resume")
+	}
+}
+
+func TestPauseGateWaitStopsOnCancel(t *testing.T) {
+	g := &pauseGate{}
+	g.pause("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.wait(ctx); err == nil {
+		t.Fatal("expected wait to fail once the context is cancelled")
+	}
+}
+
+func TestPauseGateIdempotent(t *testing.T) {
+	g := &pauseGate{}
+	g.resume("test") // resuming an unpaused gate is a no-op
+	g.pause("test")
+	g.pause("test")
+	g.resume("test")
+	g.resume("test")
+	if g.isPaused() {
+		t.Fatal("gate still paused after resume")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	log "github.com/sirupsen/logrus"
+)
+
+// pushMetrics pushes all registered metrics to the configured Pushgateway.
+// Batch-style deployments (run, process, exit) are gone before Prometheus
+// ever scrapes them; pushing at shutdown keeps their runs visible. A no-op
+// when PUSHGATEWAY_URL is unset.
+func pushMetrics() {
+	if cfg.PushgatewayURL == "" {
+		return
+	}
+	pusher := push.New(cfg.PushgatewayURL, "fraud_worker").
+		Gatherer(prometheus.DefaultGatherer).
+		Grouping("instance", consumerTag())
+	if err := pusher.Push(); err != nil {
+		log.WithError(err).Error("Failed to push metrics to Pushgateway")
+	}
+}
+
+// startMetricsPusher additionally pushes on an interval for long runs, so
+// the Pushgateway stays current between the start and the final push.
+func startMetricsPusher(ctx context.Context) {
+	if cfg.PushgatewayURL == "" || cfg.PushInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.PushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pushMetrics()
+			}
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// fallingBehind reflects whether the last inspection found the queue above
+// the configured high-watermark. /readyz can report it (see
+// QUEUE_DEPTH_FAIL_READY) so an HPA reacting to readiness scales out.
+var fallingBehind atomic.Bool
+
+// queueInspector is the slice of amqp.Channel the depth check needs; an
+// interface so the watermark logic is testable without a broker.
+type queueInspector interface {
+	QueueInspect(name string) (amqp.Queue, error)
+}
+
+// startQueueDepthMonitor periodically inspects the queue and flags when its
+// depth exceeds QUEUE_DEPTH_HIGH_WATERMARK — a single worker that cannot
+// keep up looks healthy on every other signal, so the backlog itself is the
+// one to alert on. Disabled unless a high-watermark is configured.
+func startQueueDepthMonitor(ctx context.Context, conn *amqp.Connection) {
+	if cfg.QueueDepthHighWater <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.QueueDepthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A fresh channel per inspection: a failed inspect closes
+				// its channel server-side, and at this cadence reopening is
+				// cheap.
+				channel, err := conn.Channel()
+				if err != nil {
+					log.WithError(err).Warn("Queue depth inspection could not open a channel")
+					continue
+				}
+				ok := checkQueueDepth(channel)
+				channel.Close()
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// checkQueueDepth inspects the queue once and updates the backlog state and
+// metrics. It reports whether monitoring should continue: an exclusive queue
+// owned by another connection will refuse inspection forever, so that case
+// stops the monitor instead of warning every tick.
+func checkQueueDepth(channel queueInspector) bool {
+	queue, err := channel.QueueInspect(cfg.QueueName)
+	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.ResourceLocked {
+			log.WithError(err).Warn("Queue is exclusive to another connection, disabling depth monitoring")
+			return false
+		}
+		log.WithError(err).Warn("Queue depth inspection failed")
+		return true
+	}
+
+	queueDepth.Set(float64(queue.Messages))
+	if queue.Messages > cfg.QueueDepthHighWater {
+		queueBacklogged.Set(1)
+		// Warn once per excursion, not once per tick.
+		if !fallingBehind.Swap(true) {
+			log.WithFields(log.Fields{
+				"depth":         queue.Messages,
+				"highWatermark": cfg.QueueDepthHighWater,
+			}).Warn("Queue depth above high-watermark, worker is falling behind")
+		}
+	} else {
+		queueBacklogged.Set(0)
+		if fallingBehind.Swap(false) {
+			log.WithField("depth", queue.Messages).Info("Queue depth back below high-watermark")
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+type fakeInspector struct {
+	messages int
+	err      error
+}
+
+func (f fakeInspector) QueueInspect(string) (amqp.Queue, error) {
+	return amqp.Queue{Messages: f.messages}, f.err
+}
+
+func TestCheckQueueDepthFlagsAndClearsBacklog(t *testing.T) {
+	cfg = loadConfig()
+	cfg.QueueDepthHighWater = 100
+	defer fallingBehind.Store(false)
+
+	if !checkQueueDepth(fakeInspector{messages: 250}) {
+		t.Fatal("checkQueueDepth above watermark stopped monitoring")
+	}
+	if !fallingBehind.Load() {
+		t.Error("depth 250 with watermark 100 did not set fallingBehind")
+	}
+
+	if !checkQueueDepth(fakeInspector{messages: 10}) {
+		t.Fatal("checkQueueDepth below watermark stopped monitoring")
+	}
+	if fallingBehind.Load() {
+		t.Error("depth 10 with watermark 100 left fallingBehind set")
+	}
+}
+
+func TestCheckQueueDepthStopsOnExclusiveQueue(t *testing.T) {
+	cfg = loadConfig()
+	cfg.QueueDepthHighWater = 100
+
+	locked := &amqp.Error{Code: amqp.ResourceLocked, Reason: "exclusive queue"}
+	if checkQueueDepth(fakeInspector{err: locked}) {
+		t.Error("resource-locked inspect did not stop the monitor")
+	}
+
+	// Any other failure is transient (broker restart, channel hiccup) and
+	// monitoring should carry on.
+	other := &amqp.Error{Code: amqp.ChannelError, Reason: "broker restarting"}
+	if !checkQueueDepth(fakeInspector{err: other}) {
+		t.Error("transient inspect failure stopped the monitor")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// cfgMu guards the reloadable subset of Config (log level, rate limit, page
+// size, dry-run). Connection-level settings still require a restart. Code
+// reading a reloadable field must go through the accessors below.
+var cfgMu sync.RWMutex
+
+func pageSize() int {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg.CdqPageSize
+}
+
+func dryRun() bool {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg.DryRun
+}
+
+// watchReload re-reads the reloadable settings on SIGHUP so tunables can be
+// changed without interrupting in-flight work.
+func watchReload() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			reloadConfig()
+		}
+	}()
+}
+
+func reloadConfig() {
+	fresh := loadConfig()
+	changed := log.Fields{}
+
+	cfgMu.Lock()
+	if fresh.LogLevel != cfg.LogLevel {
+		changed["logLevel"] = fresh.LogLevel
+		cfg.LogLevel = fresh.LogLevel
+	}
+	if fresh.CdqPageSize != cfg.CdqPageSize {
+		changed["cdqPageSize"] = fresh.CdqPageSize
+		cfg.CdqPageSize = fresh.CdqPageSize
+	}
+	if fresh.DryRun != cfg.DryRun {
+		changed["dryRun"] = fresh.DryRun
+		cfg.DryRun = fresh.DryRun
+	}
+	if fresh.CdqRateLimit != cfg.CdqRateLimit {
+		changed["cdqRateLimitRps"] = fresh.CdqRateLimit
+		cfg.CdqRateLimit = fresh.CdqRateLimit
+	}
+	cfgMu.Unlock()
+
+	if len(changed) == 0 {
+		log.Info("SIGHUP received, no reloadable settings changed")
+		return
+	}
+
+	if _, ok := changed["logLevel"]; ok {
+		if level, err := log.ParseLevel(fresh.LogLevel); err == nil {
+			log.SetLevel(level)
+		} else {
+			log.WithField("logLevel", fresh.LogLevel).Warn("Ignoring invalid LOG_LEVEL on reload")
+		}
+	}
+	if _, ok := changed["cdqRateLimitRps"]; ok {
+		cfgMu.RLock()
+		limiter := cdqLimiter
+		cfgMu.RUnlock()
+		if limiter != nil && fresh.CdqRateLimit > 0 {
+			limiter.SetLimit(rate.Limit(fresh.CdqRateLimit))
+		} else {
+			// Installs a fresh limiter, or clears it when the new rate
+			// is zero; initCdqLimiter swaps the pointer under cfgMu.
+			initCdqLimiter(cfg)
+		}
+	}
+
+	log.WithFields(changed).Info("Reloaded config on SIGHUP")
+}
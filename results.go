@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// resultPub publishes sync completion events when RMQ_RESULT_EXCHANGE is
+// configured; nil otherwise. It owns its own channel in confirm mode so a
+// dropped event is detected instead of silently lost.
+var resultPub *resultPublisher
+
+type resultPublisher struct {
+	mu       sync.Mutex
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func initResultPublisher(conn *amqp.Connection) error {
+	if cfg.ResultExchange == "" {
+		return nil
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := channel.Confirm(false); err != nil {
+		return err
+	}
+	resultPub = &resultPublisher{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+	}
+	return nil
+}
+
+// publishCompletion emits one completion event for a finished message so
+// downstream systems can react without polling. Only terminal outcomes are
+// published: a requeued message has not finished. Publishing problems are
+// logged but never change the message's ack outcome.
+func publishCompletion(msg amqp.Delivery, res *ProcessResult, procErr error) {
+	if resultPub == nil {
+		return
+	}
+
+	event := map[string]interface{}{
+		"pagesFetched":  res.PagesFetched,
+		"casesUpserted": res.CasesUpserted,
+		"casesSkipped":  res.CasesSkipped,
+		"deleteCutoff":  res.DeleteCutoff.UTC().Format(time.RFC3339),
+		"duration":      res.Duration.String(),
+		"success":       procErr == nil,
+	}
+	if apiKey, err := extractAPIKey(msg); err == nil {
+		event["tenant"] = tenantHash(apiKey)
+	}
+	if procErr != nil {
+		event["error"] = procErr.Error()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal completion event")
+		return
+	}
+
+	resultPub.mu.Lock()
+	defer resultPub.mu.Unlock()
+	err = resultPub.channel.Publish(cfg.ResultExchange, cfg.ResultRoutingKey, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Timestamp:   time.Now(),
+		Body:        body,
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to publish completion event")
+		return
+	}
+	if confirmation, ok := <-resultPub.confirms; !ok || !confirmation.Ack {
+		log.Error("Broker did not confirm completion event")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// transientError wraps failures that are expected to succeed on a later
+// attempt (timeouts, 5xx, 429). Transient failures are retried and, if they
+// survive all retries, the message is requeued instead of dead-lettered. The
+// reason is a low-cardinality label for the retry metrics.
+type transientError struct {
+	reason string
+	err    error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+func transient(reason, format string, args ...interface{}) error {
+	return &transientError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+func isTransient(err error) bool {
+	var te *transientError
+	return errors.As(err, &te)
+}
+
+func transientReason(err error) string {
+	var te *transientError
+	if errors.As(err, &te) {
+		return te.reason
+	}
+	return "unknown"
+}
+
+// retryableStatus reports whether an HTTP status code is worth retrying.
+func retryableStatus(status int) bool {
+	return status == 429 || status >= 500
+}
+
+// statusReason maps a retryable HTTP status to a retry-metric reason label.
+func statusReason(status int) string {
+	if status == 429 {
+		return "http_429"
+	}
+	return "http_5xx"
+}
+
+// statusBucket classifies an HTTP status code into a low-cardinality
+// alerting bucket: "2xx" through "5xx", with "429" split out because rate
+// limits alert differently from other client errors. Every endpoint uses
+// this one classification so dashboards can aggregate uniformly.
+func statusBucket(status int) string {
+	if status == 429 {
+		return "429"
+	}
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	case status >= 200:
+		return "2xx"
+	default:
+		return "1xx"
+	}
+}
+
+// withRetries runs fn up to attempts+1 times, backing off exponentially with
+// jitter between attempts. Only transient errors are retried. Each retry and
+// each exhausted budget is counted so quota burn is visible before it
+// becomes an outage.
+func withRetries(endpoint string, attempts int, base time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+		if attempt >= attempts {
+			retryExhausted.WithLabelValues(endpoint).Inc()
+			return err
+		}
+		retriesTotal.WithLabelValues(endpoint, transientReason(err)).Inc()
+		backoff := base << uint(attempt)
+		backoff += time.Duration(rand.Int63n(int64(backoff) + 1))
+		log.WithError(err).WithFields(log.Fields{
+			"attempt": attempt + 1,
+			"backoff": backoff.String(),
+		}).Warn("Retrying after transient failure")
+		time.Sleep(backoff)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestStatusBucket(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{200, "2xx"},
+		{204, "2xx"},
+		{301, "3xx"},
+		{400, "4xx"},
+		{404, "4xx"},
+		{429, "429"},
+		{500, "5xx"},
+		{503, "5xx"},
+		{100, "1xx"},
+	}
+	for _, tt := range tests {
+		if got := statusBucket(tt.status); got != tt.want {
+			t.Errorf("statusBucket(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// addBreadcrumb records a processing-stage breadcrumb on the message-scoped
+// Sentry hub, so a captured exception carries the trail of what happened
+// before it. Each message gets its own hub (see processDelivery) to keep
+// concurrent messages from cross-contaminating breadcrumbs.
+func addBreadcrumb(ctx context.Context, category, message string, data map[string]interface{}) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+	hub.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  category,
+		Message:   message,
+		Data:      data,
+		Level:     sentry.LevelInfo,
+		Timestamp: time.Now(),
+	}, nil)
+}
+
+// captureException reports an error on the message-scoped hub when present.
+func captureException(ctx context.Context, err error) {
+	if hub := sentry.GetHubFromContext(ctx); hub != nil {
+		hub.CaptureException(err)
+		return
+	}
+	sentry.CaptureException(err)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SyncRequest is the protobuf queue payload defined in
+// proto/sync_request.proto. The message is small enough that we decode it by
+// hand with protowire rather than carrying a protoc codegen step in the
+// build.
+type SyncRequest struct {
+	ApiKey  string
+	Options map[string]string
+}
+
+func decodeSyncRequest(data []byte) (*SyncRequest, error) {
+	req := &SyncRequest{Options: map[string]string{}}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid protobuf tag: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid api_key field: %w", protowire.ParseError(n))
+			}
+			req.ApiKey = string(v)
+			data = data[n:]
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid options field: %w", protowire.ParseError(n))
+			}
+			key, value, err := decodeOptionsEntry(v)
+			if err != nil {
+				return nil, err
+			}
+			req.Options[key] = value
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+	if req.ApiKey == "" {
+		return nil, fmt.Errorf("protobuf SyncRequest has no api_key")
+	}
+	return req, nil
+}
+
+// decodeOptionsEntry parses one map<string, string> entry submessage.
+func decodeOptionsEntry(data []byte) (string, string, error) {
+	var key, value string
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return "", "", fmt.Errorf("invalid options entry tag: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			return "", "", fmt.Errorf("unexpected wire type %d in options entry", typ)
+		}
+		v, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return "", "", fmt.Errorf("invalid options entry value: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = string(v)
+		}
+	}
+	return key, value, nil
+}
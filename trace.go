@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// The worker runs no tracer of its own; "tracing" here means propagating the
+// trace id the publisher put into the standard W3C traceparent header. With
+// TRACE_EXEMPLARS_ENABLED the latency histograms attach that id as an
+// OpenMetrics exemplar, so a latency spike in Grafana links straight to the
+// originating trace.
+
+// traceKey carries the message's trace id through the context.
+type traceKey struct{}
+
+func withTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+func traceIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// spanKey carries the publisher's span id; GCP log correlation wants it
+// alongside the trace id.
+type spanKey struct{}
+
+func withSpanID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, spanKey{}, id)
+}
+
+func spanIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(spanKey{}).(string)
+	return id
+}
+
+// parseTraceparent extracts the trace-id field from a W3C traceparent value
+// ("00-<trace-id>-<span-id>-<flags>"). Malformed values yield "" rather than
+// an error — a broken tracing header must never fail a message.
+func parseTraceparent(v string) string {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	return parts[1]
+}
+
+// parseTraceparentSpan extracts the parent span id ("00-<trace-id>-
+// <span-id>-<flags>"), with the same malformed-input tolerance as
+// parseTraceparent.
+func parseTraceparentSpan(v string) string {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[2]) != 16 {
+		return ""
+	}
+	return parts[2]
+}
+
+// applyTraceContext threads the publisher's trace context through the
+// context when a consumer for it is enabled — exemplars or the gcp telemetry
+// backend. Without either, or without the header, the context passes through
+// untouched.
+func applyTraceContext(ctx context.Context, msg amqp.Delivery) context.Context {
+	if !cfg.TraceExemplars && cfg.TelemetryBackend != telemetryBackendGCP {
+		return ctx
+	}
+	v, ok := msg.Headers["traceparent"].(string)
+	if !ok {
+		return ctx
+	}
+	if id := parseTraceparent(v); id != "" {
+		ctx = withTraceID(ctx, id)
+	}
+	if id := parseTraceparentSpan(v); id != "" {
+		ctx = withSpanID(ctx, id)
+	}
+	return ctx
+}
+
+// gcpLogFields returns the Cloud Logging correlation fields for the
+// message's trace context. Cloud Logging links entries carrying
+// logging.googleapis.com/trace to the matching Cloud Trace span, so the
+// worker's logs show up inline in the GCP trace viewer without an extra
+// collector. Empty — and therefore skipped gracefully — unless
+// TELEMETRY_BACKEND=gcp, GCP_PROJECT_ID is set and the message carried a
+// traceparent.
+func gcpLogFields(ctx context.Context) log.Fields {
+	if cfg.TelemetryBackend != telemetryBackendGCP || cfg.GcpProjectID == "" {
+		return nil
+	}
+	id := traceIDFrom(ctx)
+	if id == "" {
+		return nil
+	}
+	fields := log.Fields{
+		"logging.googleapis.com/trace": fmt.Sprintf("projects/%s/traces/%s", cfg.GcpProjectID, id),
+	}
+	if span := spanIDFrom(ctx); span != "" {
+		fields["logging.googleapis.com/spanId"] = span
+	}
+	return fields
+}
+
+// observeWithExemplar records a histogram observation, attaching the
+// message's trace id as an exemplar when one is in the context. Exemplars
+// only appear to scrapers negotiating the OpenMetrics format; plain
+// Prometheus scrapes see the histogram unchanged.
+func observeWithExemplar(ctx context.Context, obs prometheus.Observer, value float64) {
+	if id := traceIDFrom(ctx); id != "" {
+		if eo, ok := obs.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": id})
+			return
+		}
+	}
+	obs.Observe(value)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	id := "4bf92f3577b34da6a3ce929d0e0e4736"
+	if got := parseTraceparent("00-" + id + "-00f067aa0ba902b7-01"); got != id {
+		t.Errorf("parseTraceparent = %q, want %q", got, id)
+	}
+	for _, v := range []string{"", "garbage", "00-short-span-01", "00-" + id + "-spanid"} {
+		if got := parseTraceparent(v); got != "" {
+			t.Errorf("parseTraceparent(%q) = %q, want empty", v, got)
+		}
+	}
+}
+
+func TestApplyTraceContextGatedByConfig(t *testing.T) {
+	cfg = loadConfig()
+	id := "4bf92f3577b34da6a3ce929d0e0e4736"
+	msg := amqp.Delivery{Headers: amqp.Table{"traceparent": "00-" + id + "-00f067aa0ba902b7-01"}}
+
+	ctx := applyTraceContext(context.Background(), msg)
+	if got := traceIDFrom(ctx); got != "" {
+		t.Errorf("trace id threaded with exemplars disabled: %q", got)
+	}
+
+	cfg.TraceExemplars = true
+	ctx = applyTraceContext(context.Background(), msg)
+	if got := traceIDFrom(ctx); got != id {
+		t.Errorf("trace id = %q, want %q", got, id)
+	}
+
+	// The gcp backend threads the context too, even without exemplars.
+	cfg.TraceExemplars = false
+	cfg.TelemetryBackend = telemetryBackendGCP
+	ctx = applyTraceContext(context.Background(), msg)
+	if got := traceIDFrom(ctx); got != id {
+		t.Errorf("trace id under gcp backend = %q, want %q", got, id)
+	}
+	if got := spanIDFrom(ctx); got != "00f067aa0ba902b7" {
+		t.Errorf("span id = %q, want the traceparent's parent span", got)
+	}
+}
+
+func TestGcpLogFields(t *testing.T) {
+	cfg = loadConfig()
+	cfg.TelemetryBackend = telemetryBackendGCP
+	cfg.GcpProjectID = "acme-prod"
+	id := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	ctx := withSpanID(withTraceID(context.Background(), id), "00f067aa0ba902b7")
+	fields := gcpLogFields(ctx)
+	want := "projects/acme-prod/traces/" + id
+	if got := fields["logging.googleapis.com/trace"]; got != want {
+		t.Errorf("trace field = %v, want %q", got, want)
+	}
+	if got := fields["logging.googleapis.com/spanId"]; got != "00f067aa0ba902b7" {
+		t.Errorf("spanId field = %v, want the parent span id", got)
+	}
+
+	// Off GCP — no project id — the fields are skipped entirely.
+	cfg.GcpProjectID = ""
+	if fields := gcpLogFields(ctx); fields != nil {
+		t.Errorf("fields without a project id = %v, want nil", fields)
+	}
+	cfg.GcpProjectID = "acme-prod"
+	cfg.TelemetryBackend = telemetryBackendOTLP
+	if fields := gcpLogFields(ctx); fields != nil {
+		t.Errorf("fields under otlp backend = %v, want nil", fields)
+	}
+}
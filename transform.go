@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// TransformFunc maps one CDQ fraud case into the shape Catenax expects.
+// Mappings are registered as named profiles so a schema change in one
+// environment is a config change (TRANSFORM_PROFILE), not a code patch in
+// the upsert path.
+type TransformFunc func(FraudCases) FraudCaseObj
+
+// transformProfiles holds the registered mappings. New profiles are added
+// here, alongside a test per profile.
+var transformProfiles = map[string]TransformFunc{
+	"default": transformDefault,
+}
+
+// activeTransform is the mapping selected at startup.
+var activeTransform TransformFunc = transformDefault
+
+func initTransform(cfg *Config) error {
+	fn, ok := transformProfiles[cfg.TransformProfile]
+	if !ok {
+		return fmt.Errorf("unknown TRANSFORM_PROFILE %q", cfg.TransformProfile)
+	}
+	activeTransform = fn
+	return nil
+}
+
+// transformDefault is the historical mapping: pass fields through, with the
+// country code preferring the bank's country and falling back to the
+// business partner's.
+func transformDefault(fc FraudCases) FraudCaseObj {
+	countryCode := fc.BankCountryCode
+	if countryCode == "" {
+		countryCode = fc.BusinessPartnerCountryCode
+	}
+	return FraudCaseObj{
+		CdlId:        fc.CdlId,
+		Type:         fc.Type,
+		CountryCode:  countryCode,
+		DateOfAttack: attackDate(fc.DateOfAttack),
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestInitTransformSelectsProfile(t *testing.T) {
+	cfg = loadConfig()
+	cfg.TransformProfile = "default"
+	if err := initTransform(cfg); err != nil {
+		t.Fatalf("initTransform: %v", err)
+	}
+}
+
+func TestInitTransformRejectsUnknownProfile(t *testing.T) {
+	cfg = loadConfig()
+	cfg.TransformProfile = "no-such-profile"
+	if err := initTransform(cfg); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
+
+func TestTransformDefaultMapsFields(t *testing.T) {
+	fc := FraudCases{
+		CdlId:                      "case-1",
+		Type:                       "PHISHING",
+		DateOfAttack:               1656670800000,
+		BankCountryCode:            "DE",
+		BusinessPartnerCountryCode: "FR",
+	}
+	got := transformDefault(fc)
+	want := FraudCaseObj{CdlId: "case-1", Type: "PHISHING", CountryCode: "DE", DateOfAttack: 1656670800000}
+	if got != want {
+		t.Errorf("transformDefault = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "fmt"
+
+// Build metadata, injected at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+func printVersion() {
+	fmt.Printf("product-vas-fraud-workers %s (commit %s, built %s)\n", version, commit, buildTime)
+}